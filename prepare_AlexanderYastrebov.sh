@@ -15,4 +15,4 @@
 #  limitations under the License.
 #
 
-DOCKER_BUILDKIT=1 docker build -o target/AlexanderYastrebov src/main/go/AlexanderYastrebov
+DOCKER_BUILDKIT=1 docker build -f src/main/go/AlexanderYastrebov/Dockerfile -o target/AlexanderYastrebov src/main/go
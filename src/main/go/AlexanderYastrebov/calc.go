@@ -1,15 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"runtime"
-	"sort"
 	"sync"
 	"syscall"
+
+	"onebrc"
 )
 
 type measurement struct {
@@ -23,21 +25,27 @@ func main() {
 
 	measurements := processFile(os.Args[1])
 
+	w := bufio.NewWriter(os.Stdout)
+	printMeasurements(w, measurements)
+	w.Flush()
+}
+
+func printMeasurements(w io.Writer, measurements map[string]*measurement) {
 	ids := make([]string, 0, len(measurements))
 	for id := range measurements {
 		ids = append(ids, id)
 	}
-	sort.Strings(ids)
+	onebrc.UTF16.Sort(ids)
 
-	fmt.Print("{")
+	fmt.Fprint(w, "{")
 	for i, id := range ids {
 		if i > 0 {
-			fmt.Print(", ")
+			fmt.Fprint(w, ", ")
 		}
 		m := measurements[id]
-		fmt.Printf("%s=%.1f/%.1f/%.1f", id, round(float64(m.min)/10.0), round(float64(m.sum)/10.0/float64(m.count)), round(float64(m.max)/10.0))
+		fmt.Fprintf(w, "%s=%.1f/%.1f/%.1f", id, onebrc.Round(float64(m.min)/10.0), onebrc.Round(float64(m.sum)/10.0/float64(m.count)), onebrc.Round(float64(m.max)/10.0))
 	}
-	fmt.Println("}")
+	fmt.Fprintln(w, "}")
 }
 
 func processFile(filename string) map[string]*measurement {
@@ -197,16 +205,15 @@ func processChunk(data []byte) map[string]*measurement {
 				data = data[1:]
 			}
 
-			_ = data[3]
-			if data[1] == '.' {
+			// the last line may lack its newline
+			if len(data) < 4 || data[1] == '.' {
 				// 1.2\n
 				temp = int64(data[0])*10 + int64(data[2]) - '0'*(10+1)
-				data = data[4:]
+				data = data[min(4, len(data)):]
 				// 12.3\n
 			} else {
-				_ = data[4]
 				temp = int64(data[0])*100 + int64(data[1])*10 + int64(data[3]) - '0'*(100+10+1)
-				data = data[5:]
+				data = data[min(5, len(data)):]
 			}
 
 			if negative {
@@ -238,26 +245,6 @@ func processChunk(data []byte) map[string]*measurement {
 	return result
 }
 
-func round(x float64) float64 {
-	return roundJava(x*10.0) / 10.0
-}
-
-// roundJava returns the closest integer to the argument, with ties
-// rounding to positive infinity, see java's Math.round
-func roundJava(x float64) float64 {
-	t := math.Trunc(x)
-	if x < 0.0 && t-x == 0.5 {
-		//return t
-	} else if math.Abs(x-t) >= 0.5 {
-		t += math.Copysign(1, x)
-	}
-
-	if t == 0 { // check -0
-		return 0.0
-	}
-	return t
-}
-
 // parseNumber reads decimal number that matches "^-?[0-9]{1,2}[.][0-9]" pattern,
 // e.g.: -12.3, -3.4, 5.6, 78.9 and return the value*10, i.e. -123, -34, 56, 789.
 func parseNumber(data []byte) int64 {
@@ -2,32 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"testing"
-)
 
-func TestRoundJava(t *testing.T) {
-	for _, tc := range []struct {
-		value    float64
-		expected string
-	}{
-		{value: -1.5, expected: "-1.0"},
-		{value: -1.0, expected: "-1.0"},
-		{value: -0.7, expected: "-1.0"},
-		{value: -0.5, expected: "0.0"},
-		{value: -0.3, expected: "0.0"},
-		{value: 0.0, expected: "0.0"},
-		{value: 0.3, expected: "0.0"},
-		{value: 0.5, expected: "1.0"},
-		{value: 0.7, expected: "1.0"},
-		{value: 1.0, expected: "1.0"},
-		{value: 1.5, expected: "2.0"},
-	} {
-		if rounded := roundJava(tc.value); fmt.Sprintf("%.1f", rounded) != tc.expected {
-			t.Errorf("Wrong rounding of %v, expected: %s, got: %.1f", tc.value, tc.expected, rounded)
-		}
-	}
-}
+	"onebrc/conformance"
+)
 
 func TestParseNumber(t *testing.T) {
 	for _, tc := range []struct {
@@ -49,6 +29,24 @@ func TestParseNumber(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	conformance.Run(t, conformance.SamplesDir, func(path string, w io.Writer) error {
+		printMeasurements(w, processFile(path))
+		return nil
+	})
+}
+
+// FuzzConformance compares processFile, and so processChunk, with the
+// engines on generated measurements.
+//
+//	go test -fuzz FuzzConformance
+func FuzzConformance(f *testing.F) {
+	conformance.Fuzz(f, func(path string, w io.Writer) error {
+		printMeasurements(w, processFile(path))
+		return nil
+	})
+}
+
 var parseNumberSink int64
 
 func BenchmarkParseNumber(b *testing.B) {
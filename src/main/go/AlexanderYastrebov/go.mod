@@ -1,3 +1,7 @@
 module github.com/AlexanderYastrebov/1brc
 
-go 1.21.5
+go 1.22.0
+
+require onebrc v0.0.0
+
+replace onebrc => ../onebrc
@@ -1,3 +1,7 @@
 module 1brr_challenge
 
 go 1.22.0
+
+require onebrc v0.0.0
+
+replace onebrc => ../onebrc
@@ -3,14 +3,14 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"sort"
-	"strconv"
-	"strings"
 	"time"
+
+	"onebrc"
 )
 
 type WeatherData struct {
@@ -19,6 +19,9 @@ type WeatherData struct {
 }
 
 func main() {
+	reportPath := flag.String("report", "", "write a JSON run report to this file")
+	flag.Parse()
+
 	start := time.Now()
 
 	const filename = "../../../../measurements_big.txt"
@@ -32,25 +35,7 @@ func main() {
 		return
 	}
 
-	resultsCh := make(chan map[string]*WeatherData, len(parts))
-	for _, part := range parts {
-		go processPart(filename, part.offset, part.size, resultsCh)
-	}
-
-	weatherStats := make(map[string]*WeatherData)
-	for i := 0; i < len(parts); i++ {
-		partialResults := <-resultsCh
-		for city, data := range partialResults {
-			if _, exists := weatherStats[city]; !exists {
-				weatherStats[city] = data
-			} else {
-				weatherStats[city].min = min(weatherStats[city].min, data.min)
-				weatherStats[city].max = max(weatherStats[city].max, data.max)
-				weatherStats[city].sum += data.sum
-				weatherStats[city].count += data.count
-			}
-		}
-	}
+	weatherStats, partitions := processParts(filename, parts)
 
 	// Calculate mean for each city
 	for _, data := range weatherStats {
@@ -69,6 +54,21 @@ func main() {
 	milliseconds := int(elapsed.Milliseconds()) % 1000
 
 	fmt.Printf("Time taken to read and process the file: %02d:%02d.%03d\n", minutes, seconds, milliseconds)
+
+	if *reportPath != "" {
+		var inputBytes int64
+		for _, p := range parts {
+			inputBytes += p.size
+		}
+		report, err := newRunReport(filename, inputBytes, weatherStats, partitions, elapsed)
+		if err == nil {
+			err = writeReport(*reportPath, report)
+		}
+		if err != nil {
+			fmt.Println("Error writing report:", err)
+			return
+		}
+	}
 }
 
 func processWeatherData(filePath string) (map[string]*WeatherData, error) {
@@ -78,43 +78,12 @@ func processWeatherData(filePath string) (map[string]*WeatherData, error) {
 	}
 	defer file.Close()
 
-	weatherStats := make(map[string]*WeatherData)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		city, tempStr, hasSemi := strings.Cut(line, ";")
-		if !hasSemi {
-			fmt.Println("Invalid line format:", line)
-			continue
-		}
-		temp, err := strconv.ParseFloat(tempStr, 64)
-		if err != nil {
-			fmt.Println("Error parsing temperature:", err)
-			continue
-		}
-
-		s := weatherStats[city]
-		if s == nil {
-			s = &WeatherData{
-				min:   temp,
-				max:   temp,
-				sum:   temp,
-				count: 1,
-			}
-		} else {
-			s.min = min(s.min, temp)
-			s.max = max(s.max, temp)
-			s.sum += temp
-			s.count++
-		}
-		weatherStats[city] = s
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+	stationStats := make(map[string]*stationTenths)
+	if err := parseReader(file, stationStats); err != nil {
+		return nil, err
 	}
 
-	return weatherStats, nil
+	return toWeatherData(stationStats), nil
 }
 
 func writeWeatherData(outputPath string, weatherStats map[string]*WeatherData) error {
@@ -124,119 +93,266 @@ func writeWeatherData(outputPath string, weatherStats map[string]*WeatherData) e
 	}
 	defer outputFile.Close()
 
-	writer := bufio.NewWriter(outputFile)
-	defer writer.Flush()
+	return printWeatherData(outputFile, weatherStats)
+}
+
+// printWeatherData writes the stations to w in the reference's format.
+func printWeatherData(w io.Writer, weatherStats map[string]*WeatherData) error {
+	writer := bufio.NewWriter(w)
 
 	cities := make([]string, 0, len(weatherStats))
 	for city := range weatherStats {
 		cities = append(cities, city)
 	}
-	sort.Strings(cities)
+	onebrc.UTF16.Sort(cities)
 
-	var result string
+	writer.WriteString("{")
 	for i, city := range cities {
 		data := weatherStats[city]
-		data.mean = math.Ceil(data.sum/float64(data.count)*10) / 10
-		if i == 0 {
-			result = fmt.Sprintf("{%s=%.1f/%.1f/%.1f", city, data.min, data.mean, data.max)
-		} else {
-			result += fmt.Sprintf(", %s=%.1f/%.1f/%.1f", city, data.min, data.mean, data.max)
+		// the sum adds up float tenths, snap it back to them before dividing
+		data.mean = onebrc.Round(math.Round(data.sum*10) / 10 / float64(data.count))
+		if i > 0 {
+			writer.WriteString(", ")
 		}
+		fmt.Fprintf(writer, "%s=%.1f/%.1f/%.1f", city, data.min, data.mean, data.max)
 	}
-	result += "}"
-	writer.WriteString(result + "\n")
+	writer.WriteString("}\n")
 
-	return nil
+	return writer.Flush()
 }
 
 type part struct {
 	offset, size int64
 }
 
+// splitFile divides the file into at most numParts parts of roughly equal
+// size. Every part but the last ends right after a newline, so no line is
+// ever split between two parts regardless of its length.
 func splitFile(inputPath string, numParts int) ([]part, error) {
-	const maxLineLength = 100
-
 	f, err := os.Open(inputPath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+
 	st, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
 	size := st.Size()
-	splitSize := size / int64(numParts)
-
-	buf := make([]byte, maxLineLength)
+	n := int64(max(numParts, 1))
 
-	parts := make([]part, 0, numParts)
+	parts := make([]part, 0, n)
 	offset := int64(0)
-	for offset < size {
-		seekOffset := max(offset+splitSize-maxLineLength, 0)
-		if seekOffset > size {
-			break
-		}
-		_, err := f.Seek(seekOffset, io.SeekStart)
+	for i := int64(1); offset < size; i++ {
+		// the part ends after the first newline at or after its nominal
+		// end, i/n of the way through the file, so the last one ends at size
+		nextOffset, err := nextLineStart(f, max(size*i/n-1, offset), size)
 		if err != nil {
 			return nil, err
 		}
-		n, _ := io.ReadFull(f, buf)
-		chunk := buf[:n]
-		newline := bytes.LastIndexByte(chunk, '\n')
-		if newline < 0 {
-			return nil, fmt.Errorf("newline not found at offset %d", offset+splitSize-maxLineLength)
-		}
-		remaining := len(chunk) - newline - 1
-		nextOffset := seekOffset + int64(len(chunk)) - int64(remaining)
 		parts = append(parts, part{offset, nextOffset - offset})
 		offset = nextOffset
 	}
 	return parts, nil
 }
 
+// nextLineStart returns the offset just past the first newline at or after
+// from, or size if the rest of the file has no newline.
+func nextLineStart(f io.ReaderAt, from, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for from < size {
+		n, err := f.ReadAt(buf[:min(int64(len(buf)), size-from)], from)
+		if newline := bytes.IndexByte(buf[:n], '\n'); newline >= 0 {
+			return from + int64(newline) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		from += int64(n)
+	}
+	return size, nil
+}
+
 func processPart(inputPath string, fileOffset, fileSize int64, resultsCh chan map[string]*WeatherData) {
 	file, err := os.Open(inputPath)
 	if err != nil {
 		panic(err)
 	}
 	defer file.Close()
-	_, err = file.Seek(fileOffset, io.SeekStart)
-	if err != nil {
+
+	stationStats := make(map[string]*stationTenths)
+	if err := parseReader(io.NewSectionReader(file, fileOffset, fileSize), stationStats); err != nil {
 		panic(err)
 	}
-	f := io.LimitedReader{R: file, N: fileSize}
 
-	stationStats := make(map[string]*WeatherData)
+	resultsCh <- toWeatherData(stationStats)
+}
 
-	scanner := bufio.NewScanner(&f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		station, tempStr, hasSemi := strings.Cut(line, ";")
-		if !hasSemi {
-			continue
+// processParts runs processPart on every part concurrently and merges the
+// results. The partition reports are in the order of parts.
+func processParts(filename string, parts []part) (map[string]*WeatherData, []partitionReport) {
+	resultsCh := make(chan map[string]*WeatherData, len(parts))
+	partitions := make([]partitionReport, len(parts))
+	for i, p := range parts {
+		go func(i int, p part) {
+			partStart := time.Now()
+			partCh := make(chan map[string]*WeatherData, 1)
+			processPart(filename, p.offset, p.size, partCh)
+			partialResults := <-partCh
+
+			rows := 0
+			for _, data := range partialResults {
+				rows += data.count
+			}
+			partitions[i] = partitionReport{
+				Offset:         p.offset,
+				Bytes:          p.size,
+				Rows:           rows,
+				DurationMillis: millis(time.Since(partStart)),
+			}
+			resultsCh <- partialResults
+		}(i, p)
+	}
+
+	weatherStats := make(map[string]*WeatherData)
+	for i := 0; i < len(parts); i++ {
+		partialResults := <-resultsCh
+		for city, data := range partialResults {
+			if _, exists := weatherStats[city]; !exists {
+				weatherStats[city] = data
+			} else {
+				weatherStats[city].min = min(weatherStats[city].min, data.min)
+				weatherStats[city].max = max(weatherStats[city].max, data.max)
+				weatherStats[city].sum += data.sum
+				weatherStats[city].count += data.count
+			}
 		}
+	}
+	return weatherStats, partitions
+}
 
-		temp, err := strconv.ParseFloat(tempStr, 64)
-		if err != nil {
-			panic(err)
+// readBlockSize is the size of the blocks read by parseReader. A block always
+// holds many complete lines, the incomplete tail is carried over to the next one.
+const readBlockSize = 4 * 1024 * 1024
+
+// stationTenths accumulates temperatures as integer tenths of a degree, so the
+// hot loop never touches floating point.
+type stationTenths struct {
+	min, max, sum int64
+	count         int
+}
+
+// parseReader reads r in large blocks and accumulates every complete line into
+// stationStats. A trailing line without newline is accepted at EOF.
+func parseReader(r io.Reader, stationStats map[string]*stationTenths) error {
+	buf := make([]byte, readBlockSize)
+	filled := 0
+	for {
+		n, err := io.ReadFull(r, buf[filled:])
+		filled += n
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return fmt.Errorf("error reading file: %w", err)
 		}
 
-		s, ok := stationStats[station]
-		if !ok {
-			s = &WeatherData{
-				min:   temp,
-				max:   temp,
-				sum:   temp,
-				count: 1,
+		block := buf[:filled]
+		if !eof {
+			newline := bytes.LastIndexByte(block, '\n')
+			if newline < 0 {
+				return fmt.Errorf("line longer than %d bytes", len(buf))
 			}
+			block = block[:newline+1]
+		}
+		parseBlock(block, stationStats)
+		if eof {
+			return nil
+		}
+
+		filled = copy(buf, buf[len(block):filled])
+	}
+}
+
+// parseBlock accumulates the lines of block into stationStats. Lines without
+// a semicolon or with a temperature parseTenths rejects are skipped, as were
+// those ParseFloat failed on. The station name is only copied into a string
+// when it is seen for the first time.
+func parseBlock(block []byte, stationStats map[string]*stationTenths) {
+	for len(block) > 0 {
+		var line []byte
+		if newline := bytes.IndexByte(block, '\n'); newline >= 0 {
+			line, block = block[:newline], block[newline+1:]
+		} else {
+			line, block = block, nil
+		}
+
+		semi := bytes.IndexByte(line, ';')
+		if semi < 0 {
+			continue
+		}
+		station := line[:semi]
+
+		temp, ok := parseTenths(line[semi+1:])
+		if !ok {
+			continue
+		}
+
+		// the string conversion in a map index expression does not allocate
+		s := stationStats[string(station)]
+		if s == nil {
+			stationStats[string(station)] = &stationTenths{min: temp, max: temp, sum: temp, count: 1}
 		} else {
 			s.min = min(s.min, temp)
 			s.max = max(s.max, temp)
 			s.sum += temp
 			s.count++
 		}
-		stationStats[station] = s
 	}
+}
+
+// parseTenths parses a temperature with exactly one fractional digit, e.g.
+// "-12.3", and returns it in tenths of a degree, i.e. -123.
+func parseTenths(b []byte) (int64, bool) {
+	negative := len(b) > 0 && b[0] == '-'
+	if negative {
+		b = b[1:]
+	}
+	if len(b) < 3 || b[len(b)-2] != '.' {
+		return 0, false
+	}
+
+	var temp int64
+	for _, c := range b[:len(b)-2] {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		temp = temp*10 + int64(c-'0')
+	}
+	c := b[len(b)-1]
+	if c < '0' || c > '9' {
+		return 0, false
+	}
+	temp = temp*10 + int64(c-'0')
 
-	resultsCh <- stationStats
+	if negative {
+		return -temp, true
+	}
+	return temp, true
+}
+
+// toWeatherData converts the integer accumulators into degrees.
+func toWeatherData(stationStats map[string]*stationTenths) map[string]*WeatherData {
+	weatherStats := make(map[string]*WeatherData, len(stationStats))
+	for station, s := range stationStats {
+		weatherStats[station] = &WeatherData{
+			min:   float64(s.min) / 10,
+			max:   float64(s.max) / 10,
+			sum:   float64(s.sum) / 10,
+			count: s.count,
+		}
+	}
+	return weatherStats
 }
@@ -3,9 +3,12 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"testing"
+
+	"onebrc/conformance"
 )
 
 func TestProcessWeatherData(t *testing.T) {
@@ -114,6 +117,7 @@ func TestWeatherDataProcessing(t *testing.T) {
 		{"../../../../src/test/resources/samples/measurements-rounding.txt", "../../../../src/test/resources/samples/measurements-rounding.out"},
 		{"../../../../src/test/resources/samples/measurements-short.txt", "../../../../src/test/resources/samples/measurements-short.out"},
 		{"../../../../src/test/resources/samples/measurements-shortest.txt", "../../../../src/test/resources/samples/measurements-shortest.out"},
+		{"../../../../src/test/resources/samples/measurements-utf16-order.txt", "../../../../src/test/resources/samples/measurements-utf16-order.out"},
 	}
 
 	for _, tc := range testCases {
@@ -264,3 +268,127 @@ func TestProcessPart(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTenths(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected int64
+		ok       bool
+	}{
+		{value: "-99.9", expected: -999, ok: true},
+		{value: "-1.5", expected: -15, ok: true},
+		{value: "0.0", expected: 0, ok: true},
+		{value: "12.3", expected: 123, ok: true},
+		{value: "99.9", expected: 999, ok: true},
+		{value: "", ok: false},
+		{value: "-", ok: false},
+		{value: "12", ok: false},
+		{value: "1.23", ok: false},
+		{value: "1a.3", ok: false},
+	} {
+		temp, ok := parseTenths([]byte(tc.value))
+		if ok != tc.ok || temp != tc.expected {
+			t.Errorf("parseTenths(%q) = %d, %v; expected %d, %v", tc.value, temp, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestParseBlockSkipsInvalid(t *testing.T) {
+	stationStats := make(map[string]*stationTenths)
+	parseBlock([]byte("a;1.0\nb;x\nno semicolon\na;-2.5\nc;1e2\nd;3.0"), stationStats)
+
+	expected := map[string]stationTenths{
+		"a": {min: -25, max: 10, sum: -15, count: 2},
+		"d": {min: 30, max: 30, sum: 30, count: 1},
+	}
+	if len(stationStats) != len(expected) {
+		t.Fatalf("Expected %d stations, got %d", len(expected), len(stationStats))
+	}
+	for station, e := range expected {
+		if s := stationStats[station]; s == nil || *s != e {
+			t.Errorf("%s: expected %+v, got %+v", station, e, s)
+		}
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	longName := strings.Repeat("Ä", 50) // 100 bytes, the longest legal name
+	longerName := strings.Repeat("x", 10000)
+
+	for _, tc := range []struct {
+		name     string
+		data     string
+		numParts int
+	}{
+		{name: "empty", data: "", numParts: 8},
+		{name: "single line", data: "a;1.0\n", numParts: 8},
+		{name: "tiny file", data: "a;1.0\nb;2.0\n", numParts: 8},
+		{name: "no trailing newline", data: "a;1.0\nb;2.0\nc;-3.0", numParts: 2},
+		{name: "longest legal lines", data: strings.Repeat(longName+";-99.9\n", 20), numParts: 8},
+		{name: "lines longer than the read buffer", data: strings.Repeat(longerName+";1.0\nb;2.0\n", 5), numParts: 8},
+		{name: "one part", data: strings.Repeat("a;1.0\n", 100), numParts: 1},
+		{name: "size not a multiple of the parts", data: strings.Repeat("a;1.0\n", 9), numParts: 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.CreateTemp("", "split_file_*.txt")
+			if err != nil {
+				t.Fatalf("unable to create temp file: %v", err)
+			}
+			defer os.Remove(file.Name())
+			if _, err := file.WriteString(tc.data); err != nil {
+				t.Fatalf("unable to write to temp file: %v", err)
+			}
+			file.Close()
+
+			parts, err := splitFile(file.Name(), tc.numParts)
+			if err != nil {
+				t.Fatalf("splitFile returned an error: %v", err)
+			}
+			if len(parts) > tc.numParts {
+				t.Errorf("expected at most %d parts, got %d", tc.numParts, len(parts))
+			}
+
+			offset := int64(0)
+			rows := 0
+			for _, p := range parts {
+				if p.offset != offset {
+					t.Fatalf("part %+v does not start at %d", p, offset)
+				}
+				if p.size <= 0 {
+					t.Fatalf("part %+v is empty", p)
+				}
+				offset += p.size
+				if offset < int64(len(tc.data)) && tc.data[offset-1] != '\n' {
+					t.Errorf("part %+v does not end at a line boundary", p)
+				}
+
+				resultsCh := make(chan map[string]*WeatherData, 1)
+				processPart(file.Name(), p.offset, p.size, resultsCh)
+				for _, data := range <-resultsCh {
+					rows += data.count
+				}
+			}
+			if offset != int64(len(tc.data)) {
+				t.Errorf("parts cover %d bytes, expected %d", offset, len(tc.data))
+			}
+			if expected := strings.Count(strings.TrimSuffix(tc.data, "\n"), "\n") + min(len(tc.data), 1); rows != expected {
+				t.Errorf("expected %d rows, got %d", expected, rows)
+			}
+		})
+	}
+}
+
+// FuzzConformance compares splitFile and processParts, and so processPart,
+// with the engines on generated measurements.
+//
+//	go test -fuzz FuzzConformance
+func FuzzConformance(f *testing.F) {
+	conformance.Fuzz(f, func(path string, w io.Writer) error {
+		parts, err := splitFile(path, 8)
+		if err != nil {
+			return err
+		}
+		weatherStats, _ := processParts(path, parts)
+		return printWeatherData(w, weatherStats)
+	})
+}
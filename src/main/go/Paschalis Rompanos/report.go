@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runReport is the machine-readable summary written by the -report option.
+type runReport struct {
+	InputFile      string            `json:"input_file"`
+	InputBytes     int64             `json:"input_bytes"`
+	Rows           int               `json:"rows"`
+	Stations       int               `json:"stations"`
+	DurationMillis float64           `json:"duration_ms"`
+	RowsPerSecond  float64           `json:"rows_per_second"`
+	MBPerSecond    float64           `json:"mb_per_second"`
+	Partitions     []partitionReport `json:"partitions"`
+	Resources      resourceUsage     `json:"resources"`
+}
+
+type partitionReport struct {
+	Offset         int64   `json:"offset"`
+	Bytes          int64   `json:"bytes"`
+	Rows           int     `json:"rows"`
+	DurationMillis float64 `json:"duration_ms"`
+}
+
+// resourceUsage is the subset of getrusage(2) relevant for regressions. It
+// stays zero on platforms without getrusage.
+type resourceUsage struct {
+	UserCPUMillis   float64 `json:"user_cpu_ms"`
+	SystemCPUMillis float64 `json:"system_cpu_ms"`
+	MaxRSSKB        int64   `json:"max_rss_kb"`
+	MinorFaults     int64   `json:"minor_page_faults"`
+	MajorFaults     int64   `json:"major_page_faults"`
+}
+
+func newRunReport(inputFile string, inputBytes int64, weatherStats map[string]*WeatherData, partitions []partitionReport, elapsed time.Duration) (*runReport, error) {
+	rows := 0
+	for _, data := range weatherStats {
+		rows += data.count
+	}
+
+	resources, err := readResourceUsage()
+	if err != nil {
+		return nil, fmt.Errorf("error reading resource usage: %w", err)
+	}
+
+	seconds := elapsed.Seconds()
+	return &runReport{
+		InputFile:      inputFile,
+		InputBytes:     inputBytes,
+		Rows:           rows,
+		Stations:       len(weatherStats),
+		DurationMillis: millis(elapsed),
+		RowsPerSecond:  float64(rows) / seconds,
+		MBPerSecond:    float64(inputBytes) / 1e6 / seconds,
+		Partitions:     partitions,
+		Resources:      resources,
+	}, nil
+}
+
+func writeReport(outputPath string, report *runReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	if err := os.WriteFile(outputPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteReport(t *testing.T) {
+	weatherStats := map[string]*WeatherData{
+		"City1": {min: 10.5, max: 15.7, sum: 26.2, count: 2},
+		"City2": {min: 18.9, max: 20.3, sum: 39.2, count: 3},
+	}
+	partitions := []partitionReport{
+		{Offset: 0, Bytes: 30, Rows: 3, DurationMillis: 1},
+		{Offset: 30, Bytes: 20, Rows: 2, DurationMillis: 1},
+	}
+
+	report, err := newRunReport("input.txt", 50_000_000, weatherStats, partitions, 2*time.Second)
+	if err != nil {
+		t.Fatalf("newRunReport returned an error: %v", err)
+	}
+
+	outputFile, err := os.CreateTemp("", "report.json")
+	if err != nil {
+		t.Fatalf("unable to create temp output file: %v", err)
+	}
+	defer os.Remove(outputFile.Name())
+	outputFile.Close()
+
+	if err := writeReport(outputFile.Name(), report); err != nil {
+		t.Fatalf("writeReport returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile.Name())
+	if err != nil {
+		t.Fatalf("unable to read temp output file: %v", err)
+	}
+	var decoded runReport
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+
+	if decoded.Rows != 5 || decoded.Stations != 2 || len(decoded.Partitions) != 2 {
+		t.Errorf("report counts are incorrect: %+v", decoded)
+	}
+	if decoded.RowsPerSecond != 2.5 || decoded.MBPerSecond != 25 {
+		t.Errorf("report throughput is incorrect: %+v", decoded)
+	}
+	if haveResourceUsage && decoded.Resources.MaxRSSKB <= 0 || decoded.Resources.UserCPUMillis+decoded.Resources.SystemCPUMillis <= 0 {
+		t.Errorf("report resource usage is missing: %+v", decoded.Resources)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+const haveResourceUsage = false
+
+func readResourceUsage() (resourceUsage, error) {
+	return resourceUsage{}, nil
+}
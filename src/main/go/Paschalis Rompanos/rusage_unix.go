@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const haveResourceUsage = true
+
+func readResourceUsage() (resourceUsage, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return resourceUsage{}, err
+	}
+	maxRSSKB := int64(ru.Maxrss) // kilobytes on Linux
+	if runtime.GOOS == "darwin" {
+		maxRSSKB /= 1024 // bytes on macOS
+	}
+	return resourceUsage{
+		UserCPUMillis:   millis(time.Duration(ru.Utime.Nano())),
+		SystemCPUMillis: millis(time.Duration(ru.Stime.Nano())),
+		MaxRSSKB:        maxRSSKB,
+		MinorFaults:     int64(ru.Minflt),
+		MajorFaults:     int64(ru.Majflt),
+	}, nil
+}
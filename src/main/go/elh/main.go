@@ -5,17 +5,17 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unsafe"
+
+	"onebrc"
 )
 
 // go run main.go [measurements_file]
@@ -48,11 +48,6 @@ type Stats struct {
 	Count         int
 }
 
-// rounding floats to 1 decimal place with 0.05 rounding up to 0.1
-func round(x float64) float64 {
-	return math.Floor((x+0.05)*10) / 10
-}
-
 // parseFloatFast is a high performance float parser using the assumption that
 // the byte slice will always have a single decimal digit.
 func parseFloatFast(bs []byte) float64 {
@@ -79,10 +74,23 @@ func parseFloatFast(bs []byte) float64 {
 // properly segment the entire file and not miss any data.
 func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 	stats := make(map[string]*Stats, maxNameNum)
+	skipFirst := offset != 0
+	if skipFirst {
+		// start one byte early so that a chunk starting right at a line
+		// boundary skips only the preceding newline and not its first line
+		offset--
+		size++
+	}
 	n, err := f.ReadAt(buf, offset) // load the buffer
 	if err != nil && err != io.EOF {
 		log.Fatal(err)
 	}
+	if err == io.EOF && n > 0 && buf[n-1] != '\n' {
+		// the last line of the file may lack its newline, add one. On EOF
+		// the file ended before the end of buf.
+		buf[n] = '\n'
+		n++
+	}
 
 	lastName := make([]byte, maxNameLen) // last name parsed
 	var lastNameLen int
@@ -90,7 +98,7 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 
 	// if offset is non-zero, skip to the first new line
 	var idx, start int
-	if offset != 0 {
+	if skipFirst {
 		for idx < n {
 			if buf[idx] == '\n' {
 				idx++
@@ -100,8 +108,10 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 			idx++
 		}
 	}
-	// tick tock between parsing names and values while accummulating stats
-	for {
+	// tick tock between parsing names and values while accummulating stats.
+	// terminate when we hit the first newline after the intended size OR
+	// when we hit the end of the file
+	for !(isScanningName && idx >= size) && idx < n {
 		if isScanningName {
 			for idx < n {
 				if buf[idx] == ';' {
@@ -144,36 +154,31 @@ func parseAt(f *os.File, buf []byte, offset int64, size int) map[string]*Stats {
 				idx++
 			}
 		}
-		// terminate when we hit the first newline after the intended size OR
-		// when we hit the end of the file
-		if (isScanningName && idx >= size) || idx >= n {
-			break
-		}
 	}
 
 	return stats
 }
 
-func printResults(stats map[string]*Stats) { // doesn't help
-	// sorted alphabetically for output
+func printResults(w io.Writer, stats map[string]*Stats) { // doesn't help
+	// sorted alphabetically for output, in the reference's UTF-16 order
 	names := make([]string, 0, len(stats))
 	for name := range stats {
 		names = append(names, name)
 	}
-	sort.Strings(names)
+	onebrc.UTF16.Sort(names)
 
 	var builder strings.Builder
 	for i, name := range names {
 		s := stats[name]
 		// gotcha: first round the sum to to remove float precision errors!
-		avg := round(round(s.Sum) / float64(s.Count))
+		avg := onebrc.Round(onebrc.Round(s.Sum) / float64(s.Count))
 		builder.WriteString(fmt.Sprintf("%s=%.1f/%.1f/%.1f", name, s.Min, avg, s.Max))
 		if i < len(names)-1 {
 			builder.WriteString(", ")
 		}
 	}
 
-	writer := bufio.NewWriter(os.Stdout)
+	writer := bufio.NewWriter(w)
 	fmt.Fprintf(writer, "{%s}\n", builder.String())
 	writer.Flush()
 }
@@ -232,6 +237,12 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	printResults(os.Stdout, processFile(measurementsPath, numParsers, parseChunkSize))
+}
+
+// processFile reads the file in chunks of parseChunkSize bytes and parses them
+// with numParsers concurrent parsers.
+func processFile(measurementsPath string, numParsers, parseChunkSize int) map[string]*Stats {
 	// read file
 	f, err := os.Open(measurementsPath)
 	if err != nil {
@@ -297,5 +308,5 @@ func main() {
 		}
 	}
 
-	printResults(mergedStats)
+	return mergedStats
 }
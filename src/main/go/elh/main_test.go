@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"onebrc/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	for _, tc := range []struct {
+		name                       string
+		numParsers, parseChunkSize int
+	}{
+		{name: "default", numParsers: 4, parseChunkSize: defaultParseChunkSizeMB * mb},
+		{name: "small chunks", numParsers: 4, parseChunkSize: 256},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conformance.Run(t, conformance.SamplesDir, func(path string, w io.Writer) error {
+				printResults(w, processFile(path, tc.numParsers, tc.parseChunkSize))
+				return nil
+			})
+		})
+	}
+}
+
+// FuzzConformance compares processFile, and so parseAt, with the engines on
+// generated measurements. The small chunks put chunk boundaries at every
+// possible position of a line.
+//
+//	go test -fuzz FuzzConformance
+func FuzzConformance(f *testing.F) {
+	conformance.Fuzz(f, func(path string, w io.Writer) error {
+		printResults(w, processFile(path, 4, 64))
+		return nil
+	})
+}
@@ -0,0 +1,216 @@
+package onebrc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unsafe"
+)
+
+// Aliases folds the spellings of a station into one canonical name, e.g.
+// Zurich into Zürich. A name is first normalized by the enabled rules, then
+// looked up among the variants added with Add or Read, which are normalized
+// the same way. Names without an alias are kept normalized.
+//
+// Like Filter it is applied while parsing: the engines resolve a raw name
+// once per chunk when they first see it and aggregate its rows into the
+// canonical station, before filtering, which sees canonical names. Aliases
+// is safe for concurrent use once set up and records the rows every variant
+// contributed, see Folded.
+type Aliases struct {
+	// TrimSpace removes leading and trailing white space.
+	TrimSpace bool
+	// CollapseSpace replaces runs of white space by a single space.
+	CollapseSpace bool
+	// FoldCase lowers the case of names. Add a variant for the spelling
+	// that should be printed instead.
+	FoldCase bool
+
+	names map[string]string // normalized variant -> canonical
+
+	mu     sync.Mutex
+	folded map[string]map[string]int64 // canonical -> raw variant -> rows
+}
+
+// Normalize applies the enabled rules to name.
+func (a *Aliases) Normalize(name string) string {
+	if a.CollapseSpace {
+		name = collapseSpace(name)
+	}
+	if a.TrimSpace {
+		name = strings.TrimSpace(name)
+	}
+	if a.FoldCase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Add makes variant an alias of canonical. The normalization rules must be
+// set before. The normalized canonical name is an alias of itself unless
+// added otherwise.
+func (a *Aliases) Add(variant, canonical string) error {
+	if a.names == nil {
+		a.names = make(map[string]string)
+	}
+	key := a.Normalize(variant)
+	if c, ok := a.names[key]; ok && c != canonical && a.Normalize(c) != key {
+		return fmt.Errorf("%q is an alias of both %q and %q", variant, c, canonical)
+	}
+	a.names[key] = canonical
+	if key := a.Normalize(canonical); a.names[key] == "" {
+		a.names[key] = canonical
+	}
+	return nil
+}
+
+// Read adds the aliases of an alias file with one variant;canonical pair
+// per line. Empty lines and lines starting with # are skipped.
+func (a *Aliases) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		variant, canonical, ok := strings.Cut(line, ";")
+		if !ok || canonical == "" {
+			return fmt.Errorf("line %d: expected variant;canonical, got %q", n, line)
+		}
+		if err := a.Add(variant, canonical); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Canonical returns the canonical name of the raw station name.
+func (a *Aliases) Canonical(raw []byte) string {
+	name := a.Normalize(string(raw))
+	if c, ok := a.names[name]; ok {
+		return c
+	}
+	return name
+}
+
+// Variant is a raw station name of a chunk resolved by Aliases.
+type Variant struct {
+	Canonical string
+	Rows      int64 // rows aggregated into Canonical
+}
+
+// Bytes returns Canonical without copying it. The bytes must not be
+// modified.
+func (v *Variant) Bytes() []byte {
+	return unsafe.Slice(unsafe.StringData(v.Canonical), len(v.Canonical))
+}
+
+// Variants caches the variants of the raw station names of a chunk.
+type Variants map[string]*Variant
+
+// Resolve returns the variant of raw, resolving it with a when it is seen
+// for the first time.
+func (vs Variants) Resolve(a *Aliases, raw []byte) *Variant {
+	// the string conversion in a map index expression does not allocate
+	if v := vs[string(raw)]; v != nil {
+		return v
+	}
+	v := &Variant{Canonical: a.Canonical(raw)}
+	vs[string(raw)] = v
+	return v
+}
+
+// Count records the rows of the variants of a chunk that differ from their
+// canonical names. Parsers call it once per chunk.
+func (a *Aliases) Count(vs Variants) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for raw, v := range vs {
+		if raw == v.Canonical || v.Rows == 0 {
+			continue
+		}
+		if a.folded == nil {
+			a.folded = make(map[string]map[string]int64)
+		}
+		rows := a.folded[v.Canonical]
+		if rows == nil {
+			rows = make(map[string]int64)
+			a.folded[v.Canonical] = rows
+		}
+		rows[raw] += v.Rows
+	}
+}
+
+// Folded returns the rows of every raw variant folded into a canonical name
+// so far, by canonical name and variant.
+func (a *Aliases) Folded() map[string]map[string]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	folded := make(map[string]map[string]int64, len(a.folded))
+	for canonical, rows := range a.folded {
+		folded[canonical] = make(map[string]int64, len(rows))
+		for raw, n := range rows {
+			folded[canonical][raw] = n
+		}
+	}
+	return folded
+}
+
+// WriteReport writes the folded variants as a tab separated table, e.g.
+//
+//	station	variant	rows
+//	Zürich	"Zurich"	1520
+//	Zürich	"zürich "	3
+//
+// Variants are quoted to show their white space and listed by descending
+// rows under their canonical names.
+func (a *Aliases) WriteReport(w io.Writer) error {
+	folded := a.Folded()
+	canonicals := make([]string, 0, len(folded))
+	for canonical := range folded {
+		canonicals = append(canonicals, canonical)
+	}
+	UTF16.Sort(canonicals)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("station\tvariant\trows\n")
+	for _, canonical := range canonicals {
+		rows := folded[canonical]
+		variants := make([]string, 0, len(rows))
+		for raw := range rows {
+			variants = append(variants, raw)
+		}
+		sort.Slice(variants, func(i, j int) bool {
+			if a, b := rows[variants[i]], rows[variants[j]]; a != b {
+				return a > b
+			}
+			return variants[i] < variants[j]
+		})
+		for _, raw := range variants {
+			fmt.Fprintf(bw, "%s\t%s\t%d\n", canonical, strconv.Quote(raw), rows[raw])
+		}
+	}
+	return bw.Flush()
+}
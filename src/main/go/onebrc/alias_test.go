@@ -0,0 +1,86 @@
+package onebrc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAliasesNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		aliases  *Aliases
+		name     string
+		expected string
+	}{
+		{&Aliases{}, " Zürich \t", " Zürich \t"},
+		{&Aliases{TrimSpace: true}, " Zürich \t", "Zürich"},
+		{&Aliases{CollapseSpace: true}, " San  \tJosé ", " San José "},
+		{&Aliases{CollapseSpace: true, TrimSpace: true}, " San  \tJosé ", "San José"},
+		{&Aliases{FoldCase: true}, "ZÜRICH", "zürich"},
+	} {
+		if actual := tc.aliases.Normalize(tc.name); actual != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestAliasesCanonical(t *testing.T) {
+	a := &Aliases{TrimSpace: true, CollapseSpace: true, FoldCase: true}
+	err := a.Read(strings.NewReader("# variant;canonical\nZurich;Zürich\n\nSan Jose;San José\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for raw, expected := range map[string]string{
+		"Zurich":     "Zürich",
+		" ZURICH":    "Zürich",
+		"Zürich":     "Zürich", // the canonical names are aliases of themselves
+		"zürich ":    "Zürich",
+		"san  jose":  "San José",
+		"SAN JOSÉ":   "San José",
+		"Hamburg":    "hamburg", // no alias, normalized
+		"Zurich Air": "zurich air",
+	} {
+		if actual := a.Canonical([]byte(raw)); actual != expected {
+			t.Errorf("%q: expected %q, got %q", raw, expected, actual)
+		}
+	}
+}
+
+func TestAliasesReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		file, expected string
+	}{
+		{"Zurich\n", `line 1: expected variant;canonical, got "Zurich"`},
+		{"a;b\nZurich;\n", `line 2: expected variant;canonical, got "Zurich;"`},
+		{"Zurich;Zürich\nZurich;Zug\n", `line 2: "Zurich" is an alias of both "Zürich" and "Zug"`},
+	} {
+		err := (&Aliases{}).Read(strings.NewReader(tc.file))
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("%q: expected error %q, got %v", tc.file, tc.expected, err)
+		}
+	}
+}
+
+func TestAliasesReport(t *testing.T) {
+	a := &Aliases{TrimSpace: true}
+	if err := a.Add("Zurich", "Zürich"); err != nil {
+		t.Fatal(err)
+	}
+	vs := make(Variants)
+	for _, raw := range []string{"Zurich", "Zürich ", "Zurich", "Zürich", "Abha"} {
+		vs.Resolve(a, []byte(raw)).Rows++
+	}
+	a.Count(vs)
+	vs = Variants{"Zürich ": {Canonical: "Zürich", Rows: 3}, "Zurich": {Canonical: "Zürich"}}
+	a.Count(vs)
+
+	var b strings.Builder
+	if err := a.WriteReport(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "station\tvariant\trows\n" +
+		"Zürich\t\"Zürich \"\t4\n" +
+		"Zürich\t\"Zurich\"\t2\n"
+	if actual := b.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,199 @@
+// Package anomaly compares the observed mean of every station with its
+// expected long-term mean, e.g. from data/weather_stations.csv, to
+// sanity-check generated and real datasets. A station is flagged when its
+// deviation exceeds a threshold in degrees or a number of standard errors of
+// its mean, and stations found on one side only are listed as well.
+package anomaly
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"onebrc"
+	"onebrc/gen"
+)
+
+// Reference maps station names to their expected mean in degrees.
+type Reference map[string]float64
+
+// LoadReference reads a name;mean file like data/weather_stations.csv. Like
+// the generator it keeps the first mean of a name listed twice.
+func LoadReference(path string) (Reference, error) {
+	stations, err := gen.LoadStations(path)
+	if err != nil {
+		return nil, err
+	}
+	ref := make(Reference, len(stations))
+	for _, s := range stations {
+		ref[s.Name] = s.Mean
+	}
+	return ref, nil
+}
+
+// Options are the bounds beyond which a deviation is flagged.
+type Options struct {
+	// Threshold is the largest deviation in degrees not flagged, zero
+	// disables the check.
+	Threshold float64
+	// Sigma is the largest deviation in standard errors of the observed
+	// mean not flagged, zero disables the check, see onebrc.Stats.StdDev.
+	Sigma float64
+	// Collation orders the rows, UTF16 by default.
+	Collation onebrc.Collation
+}
+
+// Flag is a set of reasons a station is reported.
+type Flag uint8
+
+const (
+	Threshold   Flag = 1 << iota // deviation beyond Options.Threshold
+	Sigma                        // deviation beyond Options.Sigma standard errors
+	NotObserved                  // in the reference only
+	NoReference                  // observed only
+)
+
+var flagNames = []string{"threshold", "sigma", "not-observed", "no-reference"}
+
+func (f Flag) String() string {
+	var names []string
+	for i, name := range flagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Row compares one station.
+type Row struct {
+	Station  string
+	Count    int64
+	Mean     float64 // observed, NaN if not observed
+	Expected float64 // NaN if not in the reference
+	// Deviation is Mean - Expected, NaN if either is missing.
+	Deviation float64
+	// StdErr is the standard error of Mean, NaN with fewer than two rows or
+	// an unknown sum of squares.
+	StdErr float64
+	Flags  Flag
+}
+
+// Report compares the stations of a result with a reference.
+type Report struct {
+	// Rows lists the stations of either side in output order.
+	Rows []Row
+}
+
+// Compare compares the observed means of r with ref.
+func Compare(r onebrc.Result, ref Reference, opts Options) *Report {
+	names := make([]string, 0, max(len(r), len(ref)))
+	for name := range r {
+		names = append(names, name)
+	}
+	for name := range ref {
+		if _, ok := r[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	opts.Collation.Sort(names)
+
+	rep := &Report{Rows: make([]Row, 0, len(names))}
+	for _, name := range names {
+		row := Row{Station: name, Mean: math.NaN(), Expected: math.NaN(), Deviation: math.NaN(), StdErr: math.NaN()}
+		s, observed := r[name]
+		if observed {
+			row.Count = s.Count
+			row.Mean = s.Mean()
+			row.StdErr = s.StdDev() / math.Sqrt(float64(s.Count))
+		}
+		expected, referenced := ref[name]
+		if referenced {
+			row.Expected = expected
+		}
+
+		switch {
+		case !observed:
+			row.Flags = NotObserved
+		case !referenced:
+			row.Flags = NoReference
+		default:
+			row.Deviation = row.Mean - row.Expected
+			dev := math.Abs(row.Deviation)
+			if opts.Threshold > 0 && dev > opts.Threshold {
+				row.Flags |= Threshold
+			}
+			// NaN compares false, so no standard error flags nothing
+			if opts.Sigma > 0 && dev > opts.Sigma*row.StdErr {
+				row.Flags |= Sigma
+			}
+		}
+		rep.Rows = append(rep.Rows, row)
+	}
+	return rep
+}
+
+// Count returns the number of rows with any of the flags f.
+func (rep *Report) Count(f Flag) int {
+	n := 0
+	for _, row := range rep.Rows {
+		if row.Flags&f != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary describes the report in one line.
+func (rep *Report) Summary() string {
+	notObserved, noReference := rep.Count(NotObserved), rep.Count(NoReference)
+	return fmt.Sprintf("%d stations compared, %d beyond the threshold, %d beyond the standard error bound, %d not observed, %d not in the reference",
+		len(rep.Rows)-notObserved-noReference, rep.Count(Threshold), rep.Count(Sigma), notObserved, noReference)
+}
+
+// Write writes the rows as a tab separated table, e.g.
+//
+//	station	count	mean	expected	deviation	stderr	flags
+//	Abha	1002	18.07	18.00	0.07	0.32
+//	Zürich	998	14.92	9.30	5.62	0.31	threshold,sigma
+//	Zagreb	0	-	10.70	-	-	not-observed
+//
+// Missing values are written as -.
+func (rep *Report) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("station\tcount\tmean\texpected\tdeviation\tstderr\tflags\n")
+	for _, row := range rep.Rows {
+		bw.WriteString(row.Station)
+		bw.WriteByte('\t')
+		bw.WriteString(strconv.FormatInt(row.Count, 10))
+		for _, x := range []float64{row.Mean, row.Expected, row.Deviation, row.StdErr} {
+			bw.WriteByte('\t')
+			bw.WriteString(formatFloat(x))
+		}
+		bw.WriteByte('\t')
+		bw.WriteString(row.Flags.String())
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func formatFloat(x float64) string {
+	if math.IsNaN(x) {
+		return "-"
+	}
+	// adding zero turns -0 into 0
+	return strconv.FormatFloat(math.Round(x*100)/100+0, 'f', 2, 64)
+}
+
+// Formatter writes the Report of a result as an onebrc.Formatter.
+type Formatter struct {
+	Reference Reference
+	Options   Options
+}
+
+func (f Formatter) Format(w io.Writer, r onebrc.Result) error {
+	return Compare(r, f.Reference, f.Options).Write(w)
+}
@@ -0,0 +1,134 @@
+package anomaly
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"onebrc"
+	"onebrc/engine/split"
+	"onebrc/gen"
+)
+
+const stationsFile = "../../../../../data/weather_stations.csv"
+
+func stats(temps ...int64) *onebrc.Stats {
+	s := &onebrc.Stats{}
+	for _, temp := range temps {
+		s.Add(temp)
+	}
+	return s
+}
+
+func TestCompare(t *testing.T) {
+	r := onebrc.Result{
+		"Abha":   stats(170, 190, 180, 180), // mean 18.0, stderr 0.41
+		"Bosaso": stats(300, 301, 299, 300), // mean 30.0, stderr 0.04
+		"Cabo":   stats(240, 260, 240, 260), // mean 25.0, stderr 0.58
+		"Dhaka":  stats(250),                // a single row
+		"Extra":  stats(10),
+	}
+	ref := Reference{"Abha": 18.0, "Bosaso": 29.5, "Cabo": 22.0, "Dhaka": 20.0, "Fes": 17.0}
+	rep := Compare(r, ref, Options{Threshold: 2, Sigma: 3})
+
+	expected := "station\tcount\tmean\texpected\tdeviation\tstderr\tflags\n" +
+		"Abha\t4\t18.00\t18.00\t0.00\t0.41\t\n" +
+		"Bosaso\t4\t30.00\t29.50\t0.50\t0.04\tsigma\n" +
+		"Cabo\t4\t25.00\t22.00\t3.00\t0.58\tthreshold,sigma\n" +
+		"Dhaka\t1\t25.00\t20.00\t5.00\t-\tthreshold\n" +
+		"Extra\t1\t1.00\t-\t-\t-\tno-reference\n" +
+		"Fes\t0\t-\t17.00\t-\t-\tnot-observed\n"
+	var b strings.Builder
+	if err := rep.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if actual := b.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	summary := "4 stations compared, 2 beyond the threshold, 2 beyond the standard error bound, 1 not observed, 1 not in the reference"
+	if actual := rep.Summary(); actual != summary {
+		t.Errorf("expected summary %q, got %q", summary, actual)
+	}
+
+	// disabled bounds flag nothing
+	if n := Compare(r, ref, Options{}).Count(Threshold | Sigma); n != 0 {
+		t.Errorf("Expected no flags without bounds, got %d", n)
+	}
+}
+
+func TestCompareCollation(t *testing.T) {
+	r := onebrc.Result{"a": stats(10), "\U0001F642": stats(10)}
+	ref := Reference{"a": 1, "\U0001F642": 1, "\uFB01": 1}
+	for _, tc := range []struct {
+		collation onebrc.Collation
+		expected  []string
+	}{
+		{onebrc.UTF16, []string{"a", "\U0001F642", "\uFB01"}},
+		{onebrc.Bytes, []string{"a", "\uFB01", "\U0001F642"}},
+	} {
+		var names []string
+		for _, row := range Compare(r, ref, Options{Collation: tc.collation}).Rows {
+			names = append(names, row.Station)
+		}
+		if strings.Join(names, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("%v: expected %q, got %q", tc.collation, tc.expected, names)
+		}
+	}
+}
+
+func TestLoadReference(t *testing.T) {
+	ref, err := LoadReference(stationsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref["Tokyo"] != 35.6897 {
+		t.Errorf("Expected Tokyo 35.6897, got %v", ref["Tokyo"])
+	}
+	if len(ref) < gen.MaxStations {
+		t.Errorf("Expected more than %d stations, got %d", gen.MaxStations, len(ref))
+	}
+}
+
+// TestGenerated checks that a generated dataset agrees with the means it
+// was generated from, and that a shifted station stands out.
+func TestGenerated(t *testing.T) {
+	ref, err := LoadReference(stationsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stations, err := gen.LoadStations(stationsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stations = stations[:100]
+	stations[7].Mean += 3
+
+	g := &gen.Generator{Stations: stations, StdDev: 10, Seed: 1, Workers: 1}
+	var buf bytes.Buffer
+	if err := g.Write(&buf, 200_000); err != nil {
+		t.Fatal(err)
+	}
+	r := make(onebrc.Result)
+	if err := split.ParseBlock(buf.Bytes(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := Compare(r, ref, Options{Threshold: 1, Sigma: 5})
+	for _, row := range rep.Rows {
+		shifted := row.Station == stations[7].Name
+		if flagged := row.Flags&(Threshold|Sigma) != 0; flagged != shifted {
+			t.Errorf("%s: flags %q, deviation %.2f, stderr %.2f", row.Station, row.Flags, row.Deviation, row.StdErr)
+		}
+		if shifted && math.Abs(row.Deviation-3) > 0.5 {
+			t.Errorf("%s: expected a deviation of about 3, got %.2f", row.Station, row.Deviation)
+		}
+	}
+	if n := rep.Count(NotObserved); n != len(ref)-100 {
+		t.Errorf("Expected %d stations not observed, got %d", len(ref)-100, n)
+	}
+	if n := rep.Count(NoReference); n != 0 {
+		t.Errorf("Expected all stations in the reference, got %d", n)
+	}
+}
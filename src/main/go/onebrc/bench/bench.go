@@ -0,0 +1,248 @@
+// Package bench builds Go implementations, runs them repeatedly on a
+// measurements file and summarizes their timings the way evaluate.sh does:
+// warmup runs are discarded and the mean is taken without the fastest and
+// slowest run.
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"onebrc/conformance"
+)
+
+// Impl is an implementation to benchmark: a Go main package and the
+// arguments passed before the measurements file.
+type Impl struct {
+	Name string   `json:"name"`
+	Dir  string   `json:"dir"`
+	Args []string `json:"args,omitempty"`
+}
+
+// ParseImpl parses "[name=]dir [args...]". The name defaults to the base
+// name of dir followed by the arguments.
+func ParseImpl(spec string) (Impl, error) {
+	var impl Impl
+	if name, rest, ok := strings.Cut(spec, "="); ok && !strings.ContainsAny(name, " /") {
+		impl.Name, spec = name, rest
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return Impl{}, fmt.Errorf("empty implementation %q", spec)
+	}
+	impl.Dir, impl.Args = fields[0], fields[1:]
+	if impl.Name == "" {
+		impl.Name = strings.Join(append([]string{filepath.Base(impl.Dir)}, impl.Args...), " ")
+	}
+	return impl, nil
+}
+
+// unsupported are the Go implementations of the repository that cannot be
+// benchmarked, by base name of their directory.
+var unsupported = map[string]string{
+	"Paschalis Rompanos": "it reads the hardcoded ../../../../measurements_big.txt and writes output.csv instead of taking the file as an argument and printing the result",
+}
+
+// Check reports why impl cannot be benchmarked: only Go main packages taking
+// the measurements file as their last argument and printing the result are
+// supported, so neither the Java entries nor Paschalis Rompanos' are.
+func Check(impl Impl) error {
+	// ParseImpl splits a directory with spaces into the arguments
+	spec := strings.Join(append([]string{impl.Dir}, impl.Args...), " ")
+	for _, dir := range []string{impl.Dir, spec} {
+		if reason, ok := unsupported[filepath.Base(dir)]; ok {
+			return fmt.Errorf("%s is not supported: %s", impl.Name, reason)
+		}
+	}
+	matches, err := filepath.Glob(filepath.Join(impl.Dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("%s is not supported: no Go files in %s, only Go implementations can be benchmarked", impl.Name, impl.Dir)
+	}
+	return nil
+}
+
+// Build compiles impl into outDir and returns the path of the binary. It
+// fails for implementations rejected by Check.
+func Build(impl Impl, outDir string) (string, error) {
+	if err := Check(impl); err != nil {
+		return "", err
+	}
+	binary, err := filepath.Abs(filepath.Join(outDir, strings.NewReplacer(" ", "_", "/", "_").Replace(impl.Name)))
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = impl.Dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("building %s: %v\n%s", impl.Name, err, out)
+	}
+	return binary, nil
+}
+
+// Run is one timed execution.
+type Run struct {
+	Seconds     float64 `json:"seconds"`
+	UserSeconds float64 `json:"user_seconds"`
+	SysSeconds  float64 `json:"sys_seconds"`
+	MaxRSSKB    int64   `json:"max_rss_kb"`
+}
+
+// Result summarizes the runs of one implementation. It only counts if Error
+// is empty.
+type Result struct {
+	Impl        Impl    `json:"impl"`
+	Runs        []Run   `json:"runs"`
+	TrimmedMean float64 `json:"trimmed_mean_seconds"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// Config controls a benchmark.
+type Config struct {
+	File    string // measurements file, passed as the last argument
+	Warmups int
+	Runs    int
+	// Baseline is the expected output. Every run, warmups included, must
+	// produce it, station by station.
+	Baseline []byte
+}
+
+// Measure runs binary cfg.Warmups + cfg.Runs times.
+func Measure(impl Impl, binary string, cfg Config) Result {
+	result := Result{Impl: impl}
+	expected, err := conformance.Parse(string(cfg.Baseline))
+	if err != nil {
+		result.Error = fmt.Sprintf("baseline: %v", err)
+		return result
+	}
+
+	for i := 0; i < cfg.Warmups+cfg.Runs; i++ {
+		run, output, err := runOnce(binary, append(impl.Args[:len(impl.Args):len(impl.Args)], cfg.File))
+		if err == nil {
+			err = check(expected, output)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if i >= cfg.Warmups {
+			result.Runs = append(result.Runs, run)
+		}
+	}
+
+	times := make([]float64, len(result.Runs))
+	for i, run := range result.Runs {
+		times[i] = run.Seconds
+	}
+	result.TrimmedMean = TrimmedMean(times)
+	return result
+}
+
+func runOnce(binary string, args []string) (Run, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start)
+	if err != nil {
+		return Run{}, nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	run := Run{
+		Seconds:     elapsed.Seconds(),
+		UserSeconds: cmd.ProcessState.UserTime().Seconds(),
+		SysSeconds:  cmd.ProcessState.SystemTime().Seconds(),
+	}
+	if ru, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		run.MaxRSSKB = int64(ru.Maxrss) // kilobytes on Linux
+	}
+	return run, stdout.Bytes(), nil
+}
+
+func check(expected conformance.Output, output []byte) error {
+	actual, err := conformance.Parse(string(output))
+	if err != nil {
+		return fmt.Errorf("invalid output: %v", err)
+	}
+	if diffs := conformance.Diff(expected, actual); len(diffs) > 0 {
+		return fmt.Errorf("output differs from baseline in %d stations, first: %s", len(diffs), diffs[0])
+	}
+	return nil
+}
+
+// TrimmedMean returns the mean of times without the fastest and the slowest,
+// or the plain mean for fewer than three times.
+func TrimmedMean(times []float64) float64 {
+	if len(times) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), times...)
+	sort.Float64s(sorted)
+	if len(sorted) >= 3 {
+		sorted = sorted[1 : len(sorted)-1]
+	}
+	var sum float64
+	for _, t := range sorted {
+		sum += t
+	}
+	return sum / float64(len(sorted))
+}
+
+// WriteTable writes a comparison table, fastest valid implementation first
+// and failed ones last.
+func WriteTable(w io.Writer, results []Result) error {
+	sorted := append([]Result(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Error == "") != (sorted[j].Error == "") {
+			return sorted[i].Error == ""
+		}
+		return sorted[i].TrimmedMean < sorted[j].TrimmedMean
+	})
+
+	var fastest float64
+	var failed []Result
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "implementation\ttrimmed mean\trelative\tuser\tsys\tmax RSS\truns")
+	for _, r := range sorted {
+		if r.Error != "" {
+			failed = append(failed, r)
+			continue
+		}
+		if fastest == 0 {
+			fastest = r.TrimmedMean
+		}
+		var user, sys float64
+		var rss int64
+		for _, run := range r.Runs {
+			user += run.UserSeconds
+			sys += run.SysSeconds
+			rss = max(rss, run.MaxRSSKB)
+		}
+		n := float64(len(r.Runs))
+		fmt.Fprintf(tw, "%s\t%.3fs\t%.2fx\t%.3fs\t%.3fs\t%.1f MiB\t%d\n",
+			r.Impl.Name, r.TrimmedMean, r.TrimmedMean/fastest, user/n, sys/n, float64(rss)/1024, len(r.Runs))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, r := range failed {
+		if _, err := fmt.Fprintf(w, "FAILED %s: %s\n", r.Impl.Name, r.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
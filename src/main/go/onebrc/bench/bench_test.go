@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseImpl(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		expected Impl
+	}{
+		{"../elh", Impl{Name: "elh", Dir: "../elh", Args: []string{}}},
+		{"./cmd/onebrc -engine readat", Impl{Name: "onebrc -engine readat", Dir: "./cmd/onebrc", Args: []string{"-engine", "readat"}}},
+		{"readat=./cmd/onebrc -engine readat", Impl{Name: "readat", Dir: "./cmd/onebrc", Args: []string{"-engine", "readat"}}},
+	} {
+		impl, err := ParseImpl(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if impl.Name != tc.expected.Name || impl.Dir != tc.expected.Dir || strings.Join(impl.Args, " ") != strings.Join(tc.expected.Args, " ") {
+			t.Errorf("Wrong impl for %q, expected: %+v, got: %+v", tc.spec, tc.expected, impl)
+		}
+	}
+
+	if _, err := ParseImpl(" "); err == nil {
+		t.Error("Expected an error for an empty spec")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(Impl{Name: "elh", Dir: "../../elh"}); err != nil {
+		t.Errorf("Expected elh to be supported, got: %v", err)
+	}
+	for _, impl := range []Impl{
+		{Name: "rompanos", Dir: "../../Paschalis Rompanos"},
+		{Name: "rompanos", Dir: "../../Paschalis", Args: []string{"Rompanos"}},
+		{Name: "java", Dir: t.TempDir()},
+	} {
+		if _, err := Build(impl, t.TempDir()); err == nil || !strings.Contains(err.Error(), "is not supported") {
+			t.Errorf("Expected %s to be rejected, got: %v", impl.Name, err)
+		}
+	}
+}
+
+func TestTrimmedMean(t *testing.T) {
+	for _, tc := range []struct {
+		times    []float64
+		expected float64
+	}{
+		{nil, 0},
+		{[]float64{2}, 2},
+		{[]float64{1, 3}, 2},
+		{[]float64{10, 1, 2, 3, 100}, 5},
+	} {
+		if mean := TrimmedMean(tc.times); mean != tc.expected {
+			t.Errorf("Wrong trimmed mean of %v, expected: %v, got: %v", tc.times, tc.expected, mean)
+		}
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "measurements.txt")
+	if err := os.WriteFile(input, []byte("a;1.0\nb;2.0\na;2.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	impl := Impl{Name: "onebrc", Dir: "../cmd/onebrc", Args: []string{"-engine", "split"}}
+	binary, err := Build(impl, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{File: input, Warmups: 1, Runs: 3, Baseline: []byte("{a=1.0/1.5/2.0, b=2.0/2.0/2.0}\n")}
+	good := Measure(impl, binary, cfg)
+	if good.Error != "" || len(good.Runs) != 3 || good.TrimmedMean <= 0 {
+		t.Fatalf("Unexpected result: %+v", good)
+	}
+	if run := good.Runs[0]; run.UserSeconds+run.SysSeconds <= 0 || run.MaxRSSKB <= 0 {
+		t.Errorf("Missing resource usage: %+v", run)
+	}
+
+	cfg.Baseline = []byte("{a=1.0/1.6/2.0, b=2.0/2.0/2.0}\n")
+	bad := Measure(Impl{Name: "wrong", Args: impl.Args}, binary, cfg)
+	if !strings.Contains(bad.Error, `"a": mean expected 1.6, got 1.5`) || len(bad.Runs) != 0 {
+		t.Errorf("Expected a baseline mismatch, got: %+v", bad)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, []Result{bad, good}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "onebrc ") || !strings.HasPrefix(lines[2], "FAILED wrong:") {
+		t.Errorf("Wrong table:\n%s", buf.String())
+	}
+}
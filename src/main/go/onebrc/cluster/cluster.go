@@ -0,0 +1,359 @@
+// Package cluster spreads the aggregation of one file over several worker
+// processes. A coordinator splits the file into newline aligned byte ranges
+// with split.SplitFile and hands them out over a TCP or Unix socket. Workers
+// parse their range and return the partial aggregate as a snapshot.
+//
+// A range whose worker disconnects or reports an error is queued again. A
+// range that takes longer than the task timeout is additionally handed to the
+// next idle worker, the first result wins. Once the last worker is gone and
+// no other connects within the task timeout, the job fails.
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"onebrc"
+	"onebrc/engine/split"
+	"onebrc/snapshot"
+)
+
+// DefaultTaskTimeout is the time after which a range is also given to
+// another worker.
+const DefaultTaskTimeout = 30 * time.Second
+
+// maxAttempts is the number of failed attempts after which a range fails the
+// whole job.
+const maxAttempts = 3
+
+type task struct {
+	ID     int
+	Path   string
+	Offset int64
+	Size   int64
+}
+
+type taskResult struct {
+	ID       int
+	Snapshot []byte
+	Err      string
+}
+
+// message is the unit of the gob encoded protocol. The coordinator sends
+// tasks or Done, workers answer every task with a result.
+type message struct {
+	Task   *task
+	Result *taskResult
+	Done   bool
+}
+
+// Coordinator hands out the ranges of one file.
+type Coordinator struct {
+	path        string
+	taskTimeout time.Duration
+
+	mu       sync.Mutex
+	changed  *sync.Cond
+	pending  []int // queued task IDs
+	tasks    []task
+	started  map[int]time.Time // in-flight tasks by start of their latest attempt
+	running  map[int]int       // attempts in flight per task
+	failures map[int]int
+	done     []bool
+	left     int
+	result   onebrc.Result
+	err      error
+
+	workers   int       // connected workers
+	idleSince time.Time // when the last worker left, zero before the first
+}
+
+// NewCoordinator splits the file at path into at most ranges parts. A zero
+// taskTimeout selects DefaultTaskTimeout.
+func NewCoordinator(path string, ranges int, taskTimeout time.Duration) (*Coordinator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	parts, err := split.SplitFile(f, st.Size(), ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	if taskTimeout <= 0 {
+		taskTimeout = DefaultTaskTimeout
+	}
+	c := &Coordinator{
+		path:        path,
+		taskTimeout: taskTimeout,
+		started:     make(map[int]time.Time),
+		running:     make(map[int]int),
+		failures:    make(map[int]int),
+		done:        make([]bool, len(parts)),
+		left:        len(parts),
+		result:      make(onebrc.Result),
+	}
+	c.changed = sync.NewCond(&c.mu)
+	for i, p := range parts {
+		c.tasks = append(c.tasks, task{ID: i, Path: path, Offset: p.Offset, Size: p.Size})
+		c.pending = append(c.pending, i)
+	}
+	return c, nil
+}
+
+// Serve accepts workers on ln until every range is aggregated or a range
+// failed too often. It closes ln and all worker connections before returning.
+func (c *Coordinator) Serve(ln net.Listener) (onebrc.Result, error) {
+	var conns sync.WaitGroup
+	var connsMu sync.Mutex
+	open := make(map[net.Conn]bool)
+
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			connsMu.Lock()
+			open[conn] = true
+			connsMu.Unlock()
+
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				c.handle(conn)
+				connsMu.Lock()
+				delete(open, conn)
+				connsMu.Unlock()
+				conn.Close()
+			}()
+		}
+	}()
+
+	// wake up waiting handlers to notice tasks that exceeded their timeout
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(min(c.taskTimeout/4, time.Second), time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.changed.Broadcast()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	c.mu.Lock()
+	for c.left > 0 && c.err == nil {
+		if c.workers == 0 && !c.idleSince.IsZero() && time.Since(c.idleSince) >= c.taskTimeout {
+			c.err = fmt.Errorf("no workers left with %d of %d ranges pending", c.left, len(c.tasks))
+			break
+		}
+		c.changed.Wait()
+	}
+	result, err := c.result, c.err
+	c.mu.Unlock()
+	close(stop)
+
+	ln.Close()
+	<-accepting
+	connsMu.Lock()
+	for conn := range open {
+		conn.Close()
+	}
+	connsMu.Unlock()
+	c.changed.Broadcast()
+	conns.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Coordinator) handle(conn net.Conn) {
+	c.mu.Lock()
+	c.workers++
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.workers--
+		if c.workers == 0 {
+			c.idleSince = time.Now()
+		}
+		c.mu.Unlock()
+	}()
+
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	for {
+		t, ok := c.next()
+		if !ok {
+			enc.Encode(message{Done: true})
+			return
+		}
+		if err := enc.Encode(message{Task: &t}); err != nil {
+			c.fail(t.ID, err)
+			return
+		}
+
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			c.fail(t.ID, fmt.Errorf("worker %s: %w", conn.RemoteAddr(), err))
+			return
+		}
+		if m.Result == nil || m.Result.ID != t.ID {
+			c.fail(t.ID, fmt.Errorf("worker %s: unexpected answer", conn.RemoteAddr()))
+			return
+		}
+		if m.Result.Err != "" {
+			c.fail(t.ID, errors.New(m.Result.Err))
+			continue
+		}
+		s, err := snapshot.Read(bytes.NewReader(m.Result.Snapshot))
+		if err != nil {
+			c.fail(t.ID, err)
+			continue
+		}
+		c.complete(t.ID, s.Result)
+	}
+}
+
+// next blocks until there is a task to hand out and returns false once the
+// job is over.
+func (c *Coordinator) next() (task, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.left == 0 || c.err != nil {
+			return task{}, false
+		}
+		for len(c.pending) > 0 {
+			id := c.pending[0]
+			c.pending = c.pending[1:]
+			if !c.done[id] {
+				c.started[id] = time.Now()
+				c.running[id]++
+				return c.tasks[id], true
+			}
+		}
+		// speculatively retry the slowest overdue task
+		overdue, oldest := -1, time.Now().Add(-c.taskTimeout)
+		for id, started := range c.started {
+			if started.Before(oldest) {
+				overdue, oldest = id, started
+			}
+		}
+		if overdue >= 0 {
+			c.started[overdue] = time.Now()
+			c.running[overdue]++
+			return c.tasks[overdue], true
+		}
+		c.changed.Wait()
+	}
+}
+
+func (c *Coordinator) complete(id int, r onebrc.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[id] {
+		return // a speculative duplicate finished first
+	}
+	c.done[id] = true
+	delete(c.started, id)
+	delete(c.running, id)
+	c.left--
+	c.result.Merge(r)
+	c.changed.Broadcast()
+}
+
+func (c *Coordinator) fail(id int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[id] {
+		return
+	}
+	c.running[id]--
+	c.failures[id]++
+	switch {
+	case c.failures[id] >= maxAttempts:
+		t := c.tasks[id]
+		c.err = fmt.Errorf("range %d-%d of %s failed %d times, last error: %w", t.Offset, t.Offset+t.Size, t.Path, maxAttempts, err)
+	case c.running[id] > 0:
+		// another attempt is still running and may be retried once overdue
+	default:
+		delete(c.started, id)
+		delete(c.running, id)
+		c.pending = append(c.pending, id)
+	}
+	c.changed.Broadcast()
+}
+
+// Work connects to a coordinator and processes ranges until it is told that
+// the job is done.
+func Work(network, addr string) error {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	for {
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return nil // the coordinator finished without us
+			}
+			return err
+		}
+		if m.Done || m.Task == nil {
+			return nil
+		}
+
+		result := &taskResult{ID: m.Task.ID}
+		if data, err := processRange(m.Task); err != nil {
+			result.Err = err.Error()
+		} else {
+			result.Snapshot = data
+		}
+		if err := enc.Encode(message{Result: result}); err != nil {
+			return err
+		}
+	}
+}
+
+func processRange(t *task) ([]byte, error) {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make(onebrc.Result)
+	if err := split.ParseReader(io.NewSectionReader(f, t.Offset, t.Size), result); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := snapshot.Write(&buf, &snapshot.Snapshot{Result: result}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
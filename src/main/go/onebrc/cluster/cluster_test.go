@@ -0,0 +1,273 @@
+package cluster
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"onebrc"
+	"onebrc/engine/split"
+	"onebrc/gen"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary doubles as worker process for TestWorkerProcesses
+	if addr := os.Getenv("ONEBRC_CLUSTER_WORKER"); addr != "" {
+		if err := Work("unix", addr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func writeMeasurements(t *testing.T, rows int64) (string, onebrc.Result) {
+	t.Helper()
+
+	stations := make([]gen.Station, 500)
+	for i := range stations {
+		stations[i] = gen.Station{Name: fmt.Sprintf("station-%d", i), Mean: float64(i%60 - 20)}
+	}
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &gen.Generator{Stations: stations, StdDev: 10, Seed: 1, Workers: 2}
+	if err := g.Write(f, rows); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	expected, err := split.New(onebrc.Options{}).Aggregate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, expected
+}
+
+func listen(t *testing.T) (net.Listener, string) {
+	t.Helper()
+
+	addr := filepath.Join(t.TempDir(), "coordinator.sock")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln, addr
+}
+
+func checkResult(t *testing.T, expected, actual onebrc.Result) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d stations, got %d", len(expected), len(actual))
+	}
+	for name, e := range expected {
+		if a := actual[name]; a == nil || *a != *e {
+			t.Fatalf("%s: expected %+v, got %+v", name, *e, a)
+		}
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	path, expected := writeMeasurements(t, 200_000)
+	c, err := NewCoordinator(path, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, addr := listen(t)
+
+	workerErrs := make(chan error, 4)
+	for range 4 {
+		go func() { workerErrs <- Work("unix", addr) }()
+	}
+
+	result, err := c.Serve(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, expected, result)
+	for range 4 {
+		if err := <-workerErrs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// badWorker takes a task and then misbehaves.
+func badWorker(t *testing.T, addr string, misbehave func(net.Conn)) {
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	var m message
+	if err := gob.NewDecoder(conn).Decode(&m); err != nil || m.Task == nil {
+		t.Errorf("Expected a task, got %+v, %v", m, err)
+		return
+	}
+	misbehave(conn)
+}
+
+func TestFailedAndSlowWorkersAreReplaced(t *testing.T) {
+	path, expected := writeMeasurements(t, 100_000)
+	c, err := NewCoordinator(path, 8, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, addr := listen(t)
+
+	// both misbehaving workers hold a task before the healthy one connects
+	crashed, stalled := make(chan struct{}), make(chan struct{})
+	go badWorker(t, addr, func(net.Conn) { close(crashed) })
+	go badWorker(t, addr, func(conn net.Conn) {
+		close(stalled)
+		conn.Read(make([]byte, 1)) // hang until the coordinator gives up on us
+	})
+	go func() {
+		<-crashed
+		<-stalled
+		Work("unix", addr)
+	}()
+
+	result, err := c.Serve(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, expected, result)
+}
+
+func TestRepeatedFailuresFailTheJob(t *testing.T) {
+	path, _ := writeMeasurements(t, 1_000)
+	c, err := NewCoordinator(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, addr := listen(t)
+
+	go func() {
+		for range maxAttempts {
+			badWorker(t, addr, func(net.Conn) {})
+		}
+	}()
+
+	if _, err := c.Serve(ln); err == nil || !strings.Contains(err.Error(), "failed 3 times") {
+		t.Errorf("Expected the job to fail, got %v", err)
+	}
+}
+
+// TestFailedSpeculativeAttempt fails the retry of an overdue range while the
+// first attempt is still running, which must still be waited for.
+func TestFailedSpeculativeAttempt(t *testing.T) {
+	path, expected := writeMeasurements(t, 1_000)
+	c, err := NewCoordinator(path, 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, addr := listen(t)
+
+	// the slow worker answers after the retry failed, the range must not be
+	// handed out a third time in between
+	type served struct {
+		result onebrc.Result
+		err    error
+	}
+	done := make(chan served)
+	go func() {
+		result, err := c.Serve(ln)
+		done <- served{result, err}
+	}()
+	retried := make(chan struct{})
+	go badWorker(t, addr, func(conn net.Conn) {
+		<-retried
+		time.Sleep(100 * time.Millisecond)
+		data, err := processRange(&c.tasks[0])
+		if err != nil {
+			t.Error(err)
+		}
+		gob.NewEncoder(conn).Encode(message{Result: &taskResult{ID: 0, Snapshot: data}})
+		conn.Read(make([]byte, 1)) // until the coordinator is done
+	})
+	time.Sleep(150 * time.Millisecond)
+	go badWorker(t, addr, func(net.Conn) { close(retried) })
+
+	d := <-done
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	checkResult(t, expected, d.result)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures[0] != 1 || len(c.pending) != 0 {
+		t.Errorf("Expected one failure and nothing queued, got %d and %v", c.failures[0], c.pending)
+	}
+}
+
+func TestAllWorkersGoneFailsTheJob(t *testing.T) {
+	path, _ := writeMeasurements(t, 10_000)
+	c, err := NewCoordinator(path, 4, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, addr := listen(t)
+
+	// both workers crash while holding a range
+	for range 2 {
+		go badWorker(t, addr, func(net.Conn) {})
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Serve(ln)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "no workers left") {
+			t.Errorf("Expected the job to fail, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve still waits for workers")
+	}
+}
+
+func TestWorkerProcesses(t *testing.T) {
+	path, expected := writeMeasurements(t, 300_000)
+	c, err := NewCoordinator(path, 12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, addr := listen(t)
+
+	var workers []*exec.Cmd
+	for range 3 {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Env = append(os.Environ(), "ONEBRC_CLUSTER_WORKER="+addr)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		workers = append(workers, cmd)
+	}
+
+	result, err := c.Serve(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, expected, result)
+	for _, cmd := range workers {
+		if err := cmd.Wait(); err != nil {
+			t.Error(err)
+		}
+	}
+}
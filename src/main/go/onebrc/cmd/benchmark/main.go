@@ -0,0 +1,111 @@
+// Command benchmark builds Go implementations and compares their run times on
+// one measurements file, replacing evaluate.sh without hyperfine or Docker.
+// Every run must reproduce the baseline output to count.
+//
+//	benchmark -file measurements.txt [-baseline out_expected.txt] [-warmups 1] [-runs 5] [-json results.json] impl...
+//
+// An impl is "[name=]dir [args...]": the Go main package in dir is built and
+// run with args followed by the measurements file, for example
+//
+//	benchmark -file measurements.txt ../AlexanderYastrebov ../elh 'readat=./cmd/onebrc -engine readat'
+//
+// Implementations that cannot be run that way, the Java entries and Paschalis
+// Rompanos', are rejected, see bench.Check.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"onebrc"
+	"onebrc/bench"
+	"onebrc/engine"
+)
+
+func main() {
+	file := flag.String("file", "measurements.txt", "measurements file")
+	baselinePath := flag.String("baseline", "", "expected output, computed with the "+engine.Default+" engine if unset")
+	warmups := flag.Int("warmups", 1, "untimed runs before measuring")
+	runs := flag.Int("runs", 5, "timed runs")
+	jsonPath := flag.String("json", "", "write the results as JSON to this file")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: benchmark [flags] impl...")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var impls []bench.Impl
+	for _, spec := range flag.Args() {
+		impl, err := bench.ParseImpl(spec)
+		if err == nil {
+			err = bench.Check(impl)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		impls = append(impls, impl)
+	}
+
+	baseline, err := readBaseline(*baselinePath, *file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	binDir, err := os.MkdirTemp("", "onebrc-benchmark")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+
+	cfg := bench.Config{File: *file, Warmups: *warmups, Runs: *runs, Baseline: baseline}
+	var results []bench.Result
+	for _, impl := range impls {
+		log.Printf("Benchmarking %s", impl.Name)
+		binary, err := bench.Build(impl, binDir)
+		if err != nil {
+			results = append(results, bench.Result{Impl: impl, Error: err.Error()})
+			continue
+		}
+		results = append(results, bench.Measure(impl, binary, cfg))
+	}
+
+	if *jsonPath != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*jsonPath, append(data, '\n'), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := bench.WriteTable(os.Stdout, results); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readBaseline(baselinePath, file string) ([]byte, error) {
+	if baselinePath != "" {
+		return os.ReadFile(baselinePath)
+	}
+
+	aggregator, err := engine.New(engine.Default, onebrc.Options{})
+	if err != nil {
+		return nil, err
+	}
+	result, err := aggregator.Aggregate(file)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := (onebrc.BraceFormatter{}).Format(&buf, result); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
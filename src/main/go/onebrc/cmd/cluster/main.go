@@ -0,0 +1,116 @@
+// Command cluster aggregates one file with several worker processes.
+//
+//	cluster coordinate [-listen unix:/tmp/onebrc.sock] [-ranges 64] [-task-timeout 30s] [-spawn N] measurements_file
+//	cluster work [-connect unix:/tmp/onebrc.sock]
+//
+// The coordinator prints the final output once all ranges are aggregated.
+// With -spawn it starts N local workers itself, more can join at any time
+// with "cluster work". Addresses are "unix:path" or "tcp:host:port".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"onebrc"
+	"onebrc/cluster"
+)
+
+const defaultAddr = "unix:/tmp/onebrc.sock"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "coordinate":
+		coordinate(os.Args[2:])
+	case "work":
+		work(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cluster coordinate [flags] measurements_file\n       cluster work [flags]")
+	os.Exit(2)
+}
+
+func coordinate(args []string) {
+	fs := flag.NewFlagSet("coordinate", flag.ExitOnError)
+	listen := fs.String("listen", defaultAddr, "address to accept workers on")
+	ranges := fs.Int("ranges", 64, "number of byte ranges to split the file into")
+	taskTimeout := fs.Duration("task-timeout", cluster.DefaultTaskTimeout, "hand a range to another worker after this time, fail when no worker is connected for as long")
+	spawn := fs.Int("spawn", 0, "number of local worker processes to start")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	c, err := cluster.NewCoordinator(fs.Arg(0), *ranges, *taskTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	network, addr := parseAddr(*listen)
+	if network == "unix" {
+		os.Remove(addr)
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if network == "unix" {
+		defer os.Remove(addr)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var workers []*exec.Cmd
+	for range *spawn {
+		cmd := exec.Command(self, "work", "-connect", network+":"+ln.Addr().String())
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			log.Fatal(err)
+		}
+		workers = append(workers, cmd)
+	}
+
+	result, err := c.Serve(ln)
+	for _, cmd := range workers {
+		cmd.Wait()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := (onebrc.BraceFormatter{}).Format(os.Stdout, result); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func work(args []string) {
+	fs := flag.NewFlagSet("work", flag.ExitOnError)
+	connect := fs.String("connect", defaultAddr, "coordinator address")
+	fs.Parse(args)
+
+	if err := cluster.Work(parseAddr(*connect)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseAddr(s string) (network, addr string) {
+	network, addr, ok := strings.Cut(s, ":")
+	if !ok || (network != "unix" && network != "tcp") {
+		log.Fatalf("invalid address %q, expected unix:path or tcp:host:port", s)
+	}
+	return network, addr
+}
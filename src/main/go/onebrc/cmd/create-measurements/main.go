@@ -0,0 +1,81 @@
+// Command create-measurements is the Go port of CreateMeasurements. It picks
+// stations from data/weather_stations.csv and writes Gaussian temperatures
+// around each station's mean. The same -seed always produces the same file.
+//
+//	create-measurements [-stations-file data/weather_stations.csv] [-o measurements.txt] [-seed N] [-mode normal] <number of records to create>
+//
+// -mode selects an adversarial dataset, see gen.Modes.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"onebrc/gen"
+)
+
+func main() {
+	stationsFile := flag.String("stations-file", "data/weather_stations.csv", "name;mean rows to pick stations from")
+	maxStations := flag.Int("max-stations", gen.MaxStations, "use only the first N distinct stations of the stations file")
+	output := flag.String("o", "measurements.txt", "output file")
+	seed := flag.Uint64("seed", 0, "random seed")
+	stdDev := flag.Float64("stddev", 10, "standard deviation of the temperatures")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of generating goroutines")
+	mode := flag.String("mode", "normal", "dataset shape, one of "+strings.Join(gen.Modes, ", "))
+	skew := flag.Float64("skew", gen.DefaultSkew, "Zipf exponent of the station frequencies in skewed mode, > 1")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: create-measurements [flags] <number of records to create>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	rows, err := strconv.ParseInt(flag.Arg(0), 10, 64)
+	if err != nil || rows < 0 {
+		log.Fatalf("Invalid value for <number of records to create>: %s", flag.Arg(0))
+	}
+
+	stations, err := gen.LoadStations(*stationsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(stations) > *maxStations {
+		stations = stations[:*maxStations]
+	}
+
+	start := time.Now()
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+
+	g := &gen.Generator{Stations: stations, StdDev: *stdDev, Seed: *seed, Workers: *workers}
+	if *mode == "skewed" {
+		g.Skew = *skew
+	}
+	if err := g.Configure(*mode); err != nil {
+		log.Fatal(err)
+	}
+	if err := g.Write(w, rows); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Created file with %d measurements in %d ms\n", rows, time.Since(start).Milliseconds())
+}
@@ -0,0 +1,40 @@
+// Command gzindex writes the sidecar index of multi-member gzip files, so the
+// engines can decompress the members in parallel without scanning for gzip
+// headers first.
+//
+//	gzindex file.gz...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"onebrc/engine/compressed"
+)
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: gzindex file.gz...")
+		os.Exit(1)
+	}
+
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		offsets, err := compressed.Members(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if err := compressed.WriteIndex(path, offsets); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: %d members", path, len(offsets))
+	}
+}
@@ -0,0 +1,46 @@
+// Command merge combines snapshots written by onebrc -snapshot into the final
+// output, e.g. to roll up results from different days or machines.
+//
+//	merge [-o merged.snap] snapshot...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"onebrc"
+	"onebrc/snapshot"
+)
+
+func main() {
+	output := flag.String("o", "", "also write the merged snapshot to this file")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: merge [flags] snapshot...")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	snapshots := make([]*snapshot.Snapshot, 0, flag.NArg())
+	for _, path := range flag.Args() {
+		s, err := snapshot.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	merged := snapshot.Merge(snapshots...)
+
+	if *output != "" {
+		if err := snapshot.WriteFile(*output, merged); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := (onebrc.BraceFormatter{}).Format(os.Stdout, merged.Result); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,512 @@
+// Command onebrc computes min/mean/max temperatures per station with one of
+// the shared engines.
+//
+//	onebrc [-engine mmap|readat|split] [-workers N] [-chunk-size-mb N] [-snapshot out.snap] [-state file.state] [measurements_file]
+//	onebrc [-per-file] [-workers N] [-snapshot out.snap] file|pattern|directory...
+//	onebrc -quantiles 0.5,0.95 [-compression 100] [flags] file...
+//	onebrc -top 20 -by mean|min|max|range|count|name [-order asc|desc] [flags] file...
+//	onebrc -collation utf16|bytes [flags] file...
+//	onebrc -reference data/weather_stations.csv [-threshold 2] [-sigma 4] [flags] file...
+//	onebrc [-aliases aliases.txt] [-trim] [-fold-case] [-collapse-space] [-alias-report] [flags] file...
+//	onebrc [-include re] [-exclude re] [-stations file] [-exclude-stations file] [-min-temp t] [-max-temp t] [flags] file...
+//	onebrc -query "SELECT station, mean, p95 WHERE count > 1000 ORDER BY mean DESC LIMIT 10" [flags] file...
+//	onebrc [-bucket hour|day|month] [-workers N] measurements_file
+//	onebrc -follow [-interval 1s] [-poll] [-metrics :9100] [measurements_file]
+//
+// Several files, shell patterns or directories, which are searched
+// recursively, are aggregated into one result through a single chunk queue,
+// see package multi. -per-file prints every file's result before the merged
+// one. -engine is rejected for them.
+//
+// -top and -by list only the first stations ordered by a statistic,
+// descending unless ordered by name or -order asc is given, see package rank.
+//
+// Station names are ordered by their UTF-16 code units like the reference
+// implementation, -collation bytes orders them by their UTF-8 bytes
+// instead, see onebrc.Collation.
+//
+// The filter flags select stations and readings while parsing, in every
+// engine alike, see onebrc.Filter. The number of rejected rows is logged.
+//
+// -aliases folds the variant;canonical pairs of the given file into their
+// canonical stations while parsing, after the normalization rules -trim,
+// -fold-case and -collapse-space, see onebrc.Aliases. Filters see the
+// canonical names. -alias-report writes the folded variants and their rows
+// to stderr.
+//
+// -quantiles keeps a t-digest per station and prints a table with the
+// estimated quantiles, see package tdigest. Snapshots and state files do not
+// store the digests, so it cannot be combined with -snapshot or -state.
+//
+// -reference compares every station's mean with the expected mean of a
+// name;mean file like data/weather_stations.csv and prints the deviations,
+// flagging those beyond -threshold degrees or -sigma standard errors and the
+// stations found on one side only, see package anomaly. A summary is logged.
+//
+// -query selects, filters and orders the stations with a SQL-like query
+// and prints the selected columns as a table, see package query. Digests
+// are kept if the query references quantiles.
+//
+// A file whose first line is station;timestamp;temperature is aggregated
+// per station and -bucket, day by default, and printed as a table, see
+// package timeseries.
+//
+// With -state only the lines appended since the previous run with the same
+// state file are parsed, see package incremental. With -follow the file is
+// tailed until interrupted and the results so far are printed every interval
+// in which new lines arrived, formatted like those of a single run, see
+// package follow. -metrics additionally serves them for Prometheus at
+// /metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"onebrc"
+	"onebrc/anomaly"
+	"onebrc/engine"
+	"onebrc/follow"
+	"onebrc/incremental"
+	"onebrc/metrics"
+	"onebrc/multi"
+	"onebrc/query"
+	"onebrc/rank"
+	"onebrc/snapshot"
+	"onebrc/tdigest"
+	"onebrc/timeseries"
+)
+
+const defaultMeasurementsPath = "measurements.txt"
+
+func main() {
+	engineName := flag.String("engine", engine.Default, "aggregation engine, one of mmap, readat, split")
+	workers := flag.Int("workers", 0, "number of concurrent parsers, defaults to the number of CPUs")
+	chunkSizeMB := flag.Int("chunk-size-mb", 0, "chunk size in MiB for the readat engine and for several files")
+	snapshotPath := flag.String("snapshot", "", "also save the aggregates to this snapshot file for merging")
+	statePath := flag.String("state", "", "process only lines appended since the last run with this state file")
+	followFile := flag.Bool("follow", false, "keep reading lines appended to the file and print updated results")
+	interval := flag.Duration("interval", time.Second, "with -follow, minimum time between two printed results")
+	poll := flag.Bool("poll", false, "with -follow, poll the file instead of using inotify")
+	metricsAddr := flag.String("metrics", "", "with -follow, serve Prometheus metrics at /metrics on this address")
+	perFile := flag.Bool("per-file", false, "print the result of every input file before the merged one")
+	bucket := flag.String("bucket", "", "aggregate timestamped measurements per hour, day or month, day by default")
+	quantiles := flag.String("quantiles", "", "comma separated quantiles to estimate per station, e.g. 0.5,0.95")
+	compression := flag.Float64("compression", tdigest.DefaultCompression, "with -quantiles or quantiles in -query, t-digest compression, higher is more accurate")
+	top := flag.Int("top", 0, "only print the first N stations in the -by order")
+	by := flag.String("by", "name", "order stations by name, mean, min, max, range or count")
+	order := flag.String("order", "", "asc or desc, by default desc unless ordered by name")
+	collation := flag.String("collation", "utf16", "order station names by utf16 code units like the reference, or by bytes")
+	queryText := flag.String("query", "", "SQL-like query selecting the stations and columns to print")
+	var filterFlags filterFlags
+	flag.StringVar(&filterFlags.include, "include", "", "only aggregate stations matching this regular expression")
+	flag.StringVar(&filterFlags.exclude, "exclude", "", "skip stations matching this regular expression")
+	flag.StringVar(&filterFlags.stations, "stations", "", "only aggregate the stations listed in this file, one per line")
+	flag.StringVar(&filterFlags.excludeStations, "exclude-stations", "", "skip the stations listed in this file, one per line")
+	flag.StringVar(&filterFlags.minTemp, "min-temp", "", "skip readings below this temperature")
+	flag.StringVar(&filterFlags.maxTemp, "max-temp", "", "skip readings above this temperature")
+	referencePath := flag.String("reference", "", "report the deviations of the station means from the name;mean reference file")
+	threshold := flag.Float64("threshold", 2, "with -reference, flag deviations beyond this many degrees, 0 disables")
+	sigma := flag.Float64("sigma", 4, "with -reference, flag deviations beyond this many standard errors, 0 disables")
+	var aliasFlags aliasFlags
+	flag.StringVar(&aliasFlags.path, "aliases", "", "fold the station names of this variant;canonical file into canonical ones")
+	flag.BoolVar(&aliasFlags.trim, "trim", false, "trim white space around station names")
+	flag.BoolVar(&aliasFlags.foldCase, "fold-case", false, "lower the case of station names not spelled by -aliases")
+	flag.BoolVar(&aliasFlags.collapseSpace, "collapse-space", false, "collapse runs of white space in station names")
+	aliasReport := flag.Bool("alias-report", false, "write the station name variants folded and their rows to stderr")
+	flag.Parse()
+	engineSet := false
+	flag.Visit(func(f *flag.Flag) { engineSet = engineSet || f.Name == "engine" })
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{defaultMeasurementsPath}
+	}
+
+	opts := onebrc.Options{
+		Workers:   *workers,
+		ChunkSize: *chunkSizeMB * 1024 * 1024,
+	}
+	var err error
+	if opts.Filter, err = filterFlags.filter(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Filter != nil && *statePath != "" {
+		log.Fatal("filters cannot be combined with -state")
+	}
+	if opts.Aliases, err = aliasFlags.aliases(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Aliases != nil && *statePath != "" {
+		log.Fatal("aliases cannot be combined with -state")
+	}
+	if *aliasReport && opts.Aliases == nil {
+		log.Fatal("-alias-report needs -aliases or a normalization flag")
+	}
+
+	c, err := onebrc.ParseCollation(*collation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names, err := stationOrder(*top, *by, *order, c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var formatter onebrc.Formatter = onebrc.BraceFormatter{Order: names}
+	if *quantiles != "" {
+		qs, err := parseQuantiles(*quantiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *statePath != "" || *snapshotPath != "" {
+			log.Fatal("-quantiles cannot be combined with -state or -snapshot, they do not store digests")
+		}
+		opts.Compression = *compression
+		formatter = onebrc.QuantileFormatter{Quantiles: qs, Order: names}
+	}
+	if *queryText != "" {
+		q, err := query.Parse(*queryText)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *quantiles != "" || *top != 0 {
+			log.Fatal("-query cannot be combined with -quantiles or -top")
+		}
+		if len(q.Quantiles()) > 0 {
+			if *statePath != "" || *snapshotPath != "" {
+				log.Fatal("quantiles in -query cannot be combined with -state or -snapshot, they do not store digests")
+			}
+			opts.Compression = *compression
+		}
+		q.Collation = c
+		formatter = q
+	}
+	var reference anomaly.Formatter
+	if *referencePath != "" {
+		if *quantiles != "" || *top != 0 || *queryText != "" {
+			log.Fatal("-reference cannot be combined with -quantiles, -top or -query")
+		}
+		if reference.Reference, err = anomaly.LoadReference(*referencePath); err != nil {
+			log.Fatal(err)
+		}
+		reference.Options = anomaly.Options{Threshold: *threshold, Sigma: *sigma, Collation: c}
+		formatter = reference
+	}
+
+	if *followFile {
+		if len(args) > 1 {
+			log.Fatal("-follow takes a single file")
+		}
+		if *snapshotPath != "" || *statePath != "" || *perFile || *bucket != "" || *aliasReport {
+			log.Fatal("-follow cannot be combined with -snapshot, -state, -per-file, -bucket or -alias-report")
+		}
+		if err := followMeasurements(args[0], *interval, *poll, *metricsAddr, opts, formatter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	paths, err := multi.Expand(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	timestamped := *bucket != ""
+	if !timestamped && len(paths) == 1 {
+		if timestamped, err = timeseries.HasTimestamps(paths[0]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if timestamped {
+		if len(paths) > 1 {
+			log.Fatal("timestamped measurements are only supported in a single file")
+		}
+		if *snapshotPath != "" || *statePath != "" || *perFile || *top != 0 || *quantiles != "" || *queryText != "" || *referencePath != "" {
+			log.Fatal("timestamped measurements cannot be combined with -snapshot, -state, -per-file, -top, -quantiles, -query or -reference")
+		}
+		if err := aggregateBuckets(paths[0], *bucket, opts); err != nil {
+			log.Fatal(err)
+		}
+		logFiltered(opts.Filter)
+		if *aliasReport {
+			writeAliasReport(opts.Aliases)
+		}
+		return
+	}
+
+	var result onebrc.Result
+	if len(paths) == 1 && !*perFile {
+		result, err = aggregate(paths[0], *engineName, *statePath, opts)
+	} else if *statePath != "" {
+		err = errors.New("-state takes a single file")
+	} else if engineSet {
+		err = errors.New("-engine takes a single file, several files and -per-file use the shared chunk queue")
+	} else {
+		var files map[string]onebrc.Result
+		result, files, err = multi.Aggregate(paths, opts, *perFile)
+		if err == nil && *perFile {
+			err = printPerFile(paths, files, formatter)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	logFiltered(opts.Filter)
+	if *aliasReport {
+		writeAliasReport(opts.Aliases)
+	}
+
+	if *snapshotPath != "" {
+		if err := snapshot.WriteFile(*snapshotPath, &snapshot.Snapshot{Result: result}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := formatter.Format(os.Stdout, result); err != nil {
+		log.Fatal(err)
+	}
+	if reference.Reference != nil {
+		log.Print(anomaly.Compare(result, reference.Reference, reference.Options).Summary())
+	}
+}
+
+// logFiltered logs the rows rejected by f, if set.
+func logFiltered(f *onebrc.Filter) {
+	if f != nil {
+		stationRows, tempRows := f.Rejected()
+		log.Printf("Filtered out %d rows of excluded stations and %d rows outside the temperature window", stationRows, tempRows)
+	}
+}
+
+// writeAliasReport writes the variants folded by a to stderr.
+func writeAliasReport(a *onebrc.Aliases) {
+	if err := a.WriteReport(os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type filterFlags struct {
+	include, exclude          string
+	stations, excludeStations string
+	minTemp, maxTemp          string
+}
+
+// filter returns the filter selected by the flags, nil if none is set.
+func (ff filterFlags) filter() (*onebrc.Filter, error) {
+	if ff == (filterFlags{}) {
+		return nil, nil
+	}
+
+	f := onebrc.NewFilter()
+	var err error
+	if ff.include != "" {
+		if f.Include, err = regexp.Compile(ff.include); err != nil {
+			return nil, err
+		}
+	}
+	if ff.exclude != "" {
+		if f.Exclude, err = regexp.Compile(ff.exclude); err != nil {
+			return nil, err
+		}
+	}
+	if ff.stations != "" {
+		if f.Stations, err = loadStationList(ff.stations); err != nil {
+			return nil, err
+		}
+	}
+	if ff.excludeStations != "" {
+		if f.ExcludeStations, err = loadStationList(ff.excludeStations); err != nil {
+			return nil, err
+		}
+	}
+	if ff.minTemp != "" {
+		if f.Min, err = parseTenths(ff.minTemp); err != nil {
+			return nil, err
+		}
+	}
+	if ff.maxTemp != "" {
+		if f.Max, err = parseTenths(ff.maxTemp); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+type aliasFlags struct {
+	path                          string
+	trim, foldCase, collapseSpace bool
+}
+
+// aliases returns the Aliases selected by the flags, nil if none are set.
+func (af aliasFlags) aliases() (*onebrc.Aliases, error) {
+	if af == (aliasFlags{}) {
+		return nil, nil
+	}
+
+	a := &onebrc.Aliases{TrimSpace: af.trim, FoldCase: af.foldCase, CollapseSpace: af.collapseSpace}
+	if af.path != "" {
+		f, err := os.Open(af.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := a.Read(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", af.path, err)
+		}
+	}
+	return a, nil
+}
+
+// loadStationList reads station names, one per line. Empty lines and lines
+// starting with # are skipped.
+func loadStationList(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stations := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			stations[line] = true
+		}
+	}
+	return stations, nil
+}
+
+// parseTenths parses a temperature in degrees into tenths of a degree.
+func parseTenths(s string) (int64, error) {
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0, fmt.Errorf("invalid temperature %q", s)
+	}
+	return int64(math.Round(x * 10)), nil
+}
+
+// stationOrder returns the Order function of the formatters, nil for all
+// stations by name in the default UTF-16 order.
+func stationOrder(top int, by, order string, c onebrc.Collation) (func(onebrc.Result) []string, error) {
+	key, err := rank.ParseKey(by)
+	if err != nil {
+		return nil, err
+	}
+	o := rank.Order{Key: key, Desc: key != rank.Name, Collation: c}
+	switch order {
+	case "":
+	case "asc":
+		o.Desc = false
+	case "desc":
+		o.Desc = true
+	default:
+		return nil, fmt.Errorf("unknown order %q, expected asc or desc", order)
+	}
+	if top <= 0 && o == (rank.Order{Key: rank.Name}) {
+		return nil, nil
+	}
+	return o.Names(top), nil
+}
+
+func parseQuantiles(s string) ([]float64, error) {
+	var qs []float64
+	for _, field := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile %q, expected a number from 0 to 1", field)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// aggregateBuckets prints the per-station table of a timestamped file.
+func aggregateBuckets(path, bucket string, opts onebrc.Options) error {
+	g := timeseries.Day
+	if bucket != "" {
+		var err error
+		if g, err = timeseries.ParseGranularity(bucket); err != nil {
+			return err
+		}
+	}
+	result, err := timeseries.Aggregate(path, g, opts)
+	if err != nil {
+		return err
+	}
+	return timeseries.WriteTable(os.Stdout, result, g)
+}
+
+// printPerFile prints the result of every file under a header like the one
+// of head(1), followed by the header of the merged result.
+func printPerFile(paths []string, files map[string]onebrc.Result, formatter onebrc.Formatter) error {
+	for _, path := range paths {
+		fmt.Printf("==> %s <==\n", path)
+		if err := formatter.Format(os.Stdout, files[path]); err != nil {
+			return err
+		}
+	}
+	fmt.Println("==> total <==")
+	return nil
+}
+
+func aggregate(path, engineName, statePath string, opts onebrc.Options) (onebrc.Result, error) {
+	if statePath != "" {
+		result, info, err := incremental.Update(path, statePath, opts.NumWorkers())
+		if info.FullScan {
+			log.Printf("Scanning the whole file: %s", info.Reason)
+		}
+		return result, err
+	}
+
+	aggregator, err := engine.New(engineName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return aggregator.Aggregate(path)
+}
+
+func followMeasurements(path string, interval time.Duration, poll bool, metricsAddr string, parse onebrc.Options, formatter onebrc.Formatter) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := follow.Options{Interval: interval, NoInotify: poll, Parse: parse}
+	var (
+		mu     sync.Mutex
+		latest = make(onebrc.Result)
+	)
+	if metricsAddr != "" {
+		opts.Metrics = metrics.NewProcessing()
+		current := func() onebrc.Result {
+			mu.Lock()
+			defer mu.Unlock()
+			return latest
+		}
+		http.Handle("GET /metrics", metrics.Handler(current, opts.Metrics))
+		go func() {
+			log.Fatal(http.ListenAndServe(metricsAddr, nil))
+		}()
+	}
+
+	return follow.Follow(ctx, path, opts, func(result onebrc.Result) {
+		if err := formatter.Format(os.Stdout, result); err != nil {
+			log.Fatal(err)
+		}
+		if metricsAddr != "" {
+			// the handler may still be writing the previous copy
+			c := result.Clone()
+			mu.Lock()
+			latest = c
+			mu.Unlock()
+		}
+	})
+}
@@ -0,0 +1,20 @@
+// Command server runs the HTTP ingestion and query server of package server.
+//
+//	server [-listen :8080]
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"onebrc/server"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	flag.Parse()
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, server.New()))
+}
@@ -0,0 +1,93 @@
+package onebrc
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Collation is an order of station names.
+type Collation int
+
+const (
+	// UTF16 orders names by their UTF-16 code units like Java's
+	// String.compareTo, and so the TreeMap of the reference implementation.
+	UTF16 Collation = iota
+	// Bytes orders names by their UTF-8 bytes like sort.Strings.
+	Bytes
+)
+
+// Collations are the names accepted by ParseCollation.
+var Collations = []string{"utf16", "bytes"}
+
+// ParseCollation parses one of Collations.
+func ParseCollation(s string) (Collation, error) {
+	for i, name := range Collations {
+		if s == name {
+			return Collation(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown collation %q, available: %v", s, Collations)
+}
+
+func (c Collation) String() string {
+	return Collations[c]
+}
+
+// Compare returns -1, 0 or 1 as a sorts before, equal to or after b.
+func (c Collation) Compare(a, b string) int {
+	if c == Bytes {
+		return strings.Compare(a, b)
+	}
+	return CompareUTF16(a, b)
+}
+
+// Sort sorts names in place.
+func (c Collation) Sort(names []string) {
+	if c == Bytes {
+		sort.Strings(names)
+		return
+	}
+	sort.Slice(names, func(i, j int) bool { return CompareUTF16(names[i], names[j]) < 0 })
+}
+
+// CompareUTF16 compares a and b by their UTF-16 code units without
+// converting them.
+//
+// UTF-8 byte order is code point order. UTF-16 order only differs from it
+// for runes from U+10000 on, whose surrogate pairs start with a code unit
+// in U+D800..U+DBFF and so sort before the runes U+E000..U+FFFF, e.g. 🙂
+// (U+1F642) before ｱ (U+FF71). So a and b only need to be decoded at the
+// first rune they differ in.
+func CompareUTF16(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) || i == len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+	// the bytes before i are equal, so both differing runes start here
+	for i > 0 && !utf8.RuneStart(a[i]) {
+		i--
+	}
+	ra, _ := utf8.DecodeRuneInString(a[i:])
+	rb, _ := utf8.DecodeRuneInString(b[i:])
+	if ra == rb {
+		// invalid UTF-8 decodes to utf8.RuneError
+		return strings.Compare(a[i:], b[i:])
+	}
+	return cmp.Compare(utf16Key(ra), utf16Key(rb))
+}
+
+// utf16Key returns the UTF-16 code units of r in the high and low 16 bits,
+// the low ones being zero for runes below U+10000.
+func utf16Key(r rune) int {
+	if r < 0x10000 {
+		return int(r) << 16
+	}
+	r -= 0x10000
+	return (0xd800+int(r>>10))<<16 | (0xdc00 + int(r&0x3ff))
+}
@@ -0,0 +1,76 @@
+package onebrc
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestCompareUTF16(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"a", "", 1},
+		{"Abha", "Abéché", -1},
+		{"x🙂", "xｱ", -1}, // U+1F642 is D83D DE42 in UTF-16, before U+FF71
+		{"xｱ", "x🙂", 1},
+		{"x🙂", "x😀", 1}, // both supplementary, code point order
+		{"x퟿", "x🙂", -1},
+		{"x🙂a", "x🙂b", -1},
+	} {
+		if actual := CompareUTF16(tc.a, tc.b); actual != tc.expected {
+			t.Errorf("CompareUTF16(%q, %q): expected %d, got %d", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
+
+// TestCompareUTF16Random compares CompareUTF16 with a comparison of the
+// UTF-16 encoded strings.
+func TestCompareUTF16Random(t *testing.T) {
+	runes := []rune("aZé東ｱ￦�🙂😀𝄞𠀋")
+	rnd := rand.New(rand.NewPCG(1, 2))
+	random := func() string {
+		r := make([]rune, rnd.IntN(4))
+		for i := range r {
+			r[i] = runes[rnd.IntN(len(runes))]
+		}
+		return string(r)
+	}
+	for i := 0; i < 100_000; i++ {
+		a, b := random(), random()
+		expected := slices.Compare(utf16.Encode([]rune(a)), utf16.Encode([]rune(b)))
+		if actual := CompareUTF16(a, b); actual != expected {
+			t.Fatalf("CompareUTF16(%q, %q): expected %d, got %d", a, b, expected, actual)
+		}
+	}
+}
+
+func TestCompareUTF16Invalid(t *testing.T) {
+	a, b := "x\xff", "x\xfe"
+	if CompareUTF16(a, b) != 1 || CompareUTF16(b, a) != -1 {
+		t.Errorf("Invalid UTF-8 is not ordered by bytes")
+	}
+}
+
+func TestSortedNames(t *testing.T) {
+	r := Result{"Zürich": {}, "Tokyo ｱ": {}, "Tokyo 🙂": {}, "Abha": {}}
+	if actual, expected := r.Names(), []string{"Abha", "Tokyo 🙂", "Tokyo ｱ", "Zürich"}; !slices.Equal(actual, expected) {
+		t.Errorf("Names: expected %q, got %q", expected, actual)
+	}
+	if actual, expected := r.SortedNames(Bytes), []string{"Abha", "Tokyo ｱ", "Tokyo 🙂", "Zürich"}; !slices.Equal(actual, expected) {
+		t.Errorf("SortedNames(Bytes): expected %q, got %q", expected, actual)
+	}
+
+	for _, name := range Collations {
+		c, err := ParseCollation(name)
+		if err != nil || c.String() != name {
+			t.Errorf("ParseCollation(%q): got %v, %v", name, c, err)
+		}
+	}
+	if _, err := ParseCollation("java"); err == nil {
+		t.Error("Expected an error for an unknown collation")
+	}
+}
@@ -0,0 +1,218 @@
+// Package conformance checks an implementation against the sample
+// measurements in src/test/resources/samples from within go test. Every
+// measurements-*.txt file with a matching .out file is a sample, the
+// implementation runs in-process and its output is compared per station.
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"onebrc"
+)
+
+// SamplesDir is the location of the samples relative to an implementation
+// directory in src/main/go.
+const SamplesDir = "../../../test/resources/samples"
+
+// Impl runs an implementation on the measurements file at path and writes
+// its brace formatted output to w.
+type Impl func(path string, w io.Writer) error
+
+// Sample is a pair of measurements file and expected output.
+type Sample struct {
+	Name     string // base name without extension, e.g. measurements-1
+	Input    string
+	Expected string
+}
+
+// Samples returns every *.txt file in dir that has a matching .out file.
+func Samples(dir string) ([]Sample, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, input := range inputs {
+		base := strings.TrimSuffix(input, ".txt")
+		if _, err := os.Stat(base + ".out"); err != nil {
+			continue
+		}
+		samples = append(samples, Sample{
+			Name:     filepath.Base(base),
+			Input:    input,
+			Expected: base + ".out",
+		})
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples found in %s", dir)
+	}
+	return samples, nil
+}
+
+// Run runs impl on every sample in dir as a subtest.
+func Run(t *testing.T, dir string, impl Impl) {
+	t.Helper()
+
+	samples, err := Samples(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range samples {
+		t.Run(sample.Name, func(t *testing.T) {
+			if err := Check(sample, impl); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// RunAggregator runs an engine on every sample in dir, formatting its result
+// with the reference formatter.
+func RunAggregator(t *testing.T, dir string, aggregator onebrc.Aggregator) {
+	t.Helper()
+
+	Run(t, dir, func(path string, w io.Writer) error {
+		result, err := aggregator.Aggregate(path)
+		if err != nil {
+			return err
+		}
+		return onebrc.BraceFormatter{}.Format(w, result)
+	})
+}
+
+// Check runs impl on sample and returns an error describing every
+// difference from the expected output.
+func Check(sample Sample, impl Impl) error {
+	expectedOutput, err := os.ReadFile(sample.Expected)
+	if err != nil {
+		return err
+	}
+	expected, err := Parse(string(expectedOutput))
+	if err != nil {
+		return fmt.Errorf("%s: %w", sample.Expected, err)
+	}
+
+	var buf bytes.Buffer
+	if err := impl(sample.Input, &buf); err != nil {
+		return fmt.Errorf("%s: implementation failed: %w", sample.Input, err)
+	}
+	actual, err := Parse(buf.String())
+	if err != nil {
+		return fmt.Errorf("%s: %w", sample.Input, err)
+	}
+
+	if diffs := Diff(expected, actual); len(diffs) > 0 {
+		return fmt.Errorf("%s: %d of %d stations mismatch:\n\t%s",
+			sample.Input, len(diffs), len(expected.Stations), strings.Join(diffs, "\n\t"))
+	}
+	return nil
+}
+
+// Row is the min/mean/max of one station as printed.
+type Row struct {
+	Min, Mean, Max string
+}
+
+// Output is a parsed brace output.
+type Output struct {
+	Stations map[string]Row
+	Order    []string // station names in printed order
+}
+
+var entryRe = regexp.MustCompile(`^(.*?)=(-?\d+\.\d)/(-?\d+\.\d)/(-?\d+\.\d)(?:, |$)`)
+
+// Parse parses the {name=min/mean/max, ...} output format. Surrounding
+// whitespace is ignored. Station names may contain any character, an entry
+// ends at the first "=min/mean/max" followed by ", " or the closing brace.
+func Parse(output string) (Output, error) {
+	s := strings.TrimSpace(output)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return Output{}, fmt.Errorf("output is not enclosed in braces: %.40q", s)
+	}
+	s = s[1 : len(s)-1]
+
+	out := Output{Stations: make(map[string]Row)}
+	for len(s) > 0 {
+		m := entryRe.FindStringSubmatch(s)
+		if m == nil {
+			return Output{}, fmt.Errorf("malformed entry at %.40q", s)
+		}
+		name := m[1]
+		if _, dup := out.Stations[name]; dup {
+			return Output{}, fmt.Errorf("station %q printed twice", name)
+		}
+		out.Stations[name] = Row{Min: m[2], Mean: m[3], Max: m[4]}
+		out.Order = append(out.Order, name)
+		s = s[len(m[0]):]
+	}
+	return out, nil
+}
+
+// Diff explains every difference between expected and actual, one line per
+// station, followed by a note if the stations are printed in another order.
+func Diff(expected, actual Output) []string {
+	var diffs []string
+	for _, name := range expected.Order {
+		e := expected.Stations[name]
+		a, ok := actual.Stations[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%q: missing, expected %s", name, e))
+		case a != e:
+			diffs = append(diffs, fmt.Sprintf("%q: %s", name, explain(e, a)))
+		}
+	}
+
+	var extra []string
+	for name, a := range actual.Stations {
+		if _, ok := expected.Stations[name]; !ok {
+			extra = append(extra, fmt.Sprintf("%q: unexpected station %s", name, a))
+		}
+	}
+	sort.Strings(extra)
+	diffs = append(diffs, extra...)
+
+	if len(diffs) == 0 {
+		for i, name := range expected.Order {
+			if actual.Order[i] != name {
+				diffs = append(diffs, fmt.Sprintf("order: expected %q at position %d, got %q", name, i, actual.Order[i]))
+				break
+			}
+		}
+	}
+	return diffs
+}
+
+func (r Row) String() string {
+	return r.Min + "/" + r.Mean + "/" + r.Max
+}
+
+func explain(expected, actual Row) string {
+	var parts []string
+	for _, f := range []struct {
+		field            string
+		expected, actual string
+	}{
+		{"min", expected.Min, actual.Min},
+		{"mean", expected.Mean, actual.Mean},
+		{"max", expected.Max, actual.Max},
+	} {
+		if f.expected != f.actual {
+			parts = append(parts, fmt.Sprintf("%s expected %s, got %s", f.field, f.expected, f.actual))
+		}
+	}
+	explanation := strings.Join(parts, ", ")
+	if expected.Min == actual.Min && expected.Max == actual.Max && expected.Mean != actual.Mean {
+		explanation += " (only the mean differs, check rounding)"
+	}
+	return explanation
+}
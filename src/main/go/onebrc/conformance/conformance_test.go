@@ -0,0 +1,48 @@
+package conformance
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	out, err := Parse("{-=1.0/1.5/2.0, a, b=c=-1.0/0.0/99.9, .=1.0/1.0/1.0}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"-", "a, b=c", "."}
+	if strings.Join(out.Order, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Wrong stations, expected: %q, got: %q", expected, out.Order)
+	}
+	if r := out.Stations["a, b=c"]; r != (Row{Min: "-1.0", Mean: "0.0", Max: "99.9"}) {
+		t.Errorf("Wrong row: %+v", r)
+	}
+
+	for _, malformed := range []string{"", "a=1.0/1.0/1.0", "{a=1.0/1.0}", "{a=1.0/1.0/1.0, a=1.0/1.0/1.0}"} {
+		if _, err := Parse(malformed); err == nil {
+			t.Errorf("Expected an error for %q", malformed)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	expected, _ := Parse("{a=1.0/1.5/2.0, b=1.0/1.0/1.0, c=0.0/0.0/0.0}")
+	actual, _ := Parse("{a=1.0/1.6/2.0, c=0.0/0.0/0.0, d=1.0/1.0/1.0}")
+
+	diffs := Diff(expected, actual)
+	for i, want := range []string{
+		`"a": mean expected 1.5, got 1.6 (only the mean differs, check rounding)`,
+		`"b": missing, expected 1.0/1.0/1.0`,
+		`"d": unexpected station 1.0/1.0/1.0`,
+	} {
+		if i >= len(diffs) || diffs[i] != want {
+			t.Fatalf("Wrong diffs, expected %q at %d, got: %q", want, i, diffs)
+		}
+	}
+
+	reordered, _ := Parse("{b=1.0/1.0/1.0, a=1.0/1.5/2.0, c=0.0/0.0/0.0}")
+	if diffs := Diff(expected, reordered); len(diffs) != 1 || !strings.HasPrefix(diffs[0], "order:") {
+		t.Errorf("Expected an order diff, got: %q", diffs)
+	}
+}
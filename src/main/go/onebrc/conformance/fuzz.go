@@ -0,0 +1,129 @@
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"onebrc"
+	"onebrc/engine/mmap"
+)
+
+// Seeds are the initial fuzz inputs, each a seed for Measurements.
+var Seeds = [][]byte{
+	[]byte("\x80abc\x01\x02\x00\x03\x04\x90\xff\xfe\x05\x06"),
+	bytes.Repeat([]byte{0xff, 0x10, 0x20, 0x30, 0x00, 0x40}, 50),
+	[]byte("\x8f0123456789abcdef\xe7\x03\x01\x00\x18\x03"),
+}
+
+// MaxSeedLen bounds the seed bytes turned into measurements, larger files
+// only slow the fuzzer down.
+const MaxSeedLen = 2048
+
+// Fuzz runs impl on the measurements generated from every fuzz input, see
+// Measurements, and fails where its output differs from that of the mmap
+// engine's chunk parser with the reference formatter. Failing inputs are
+// stored in testdata/fuzz of the calling package, from where go test replays
+// them.
+func Fuzz(f *testing.F, impl Impl) {
+	for _, seed := range Seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, seed []byte) {
+		if len(seed) > MaxSeedLen {
+			seed = seed[:MaxSeedLen]
+		}
+		data := Measurements(seed)
+		if len(data) == 0 {
+			t.Skip("the implementations expect at least one line")
+		}
+		path := filepath.Join(t.TempDir(), "measurements.txt")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var expected bytes.Buffer
+		if err := (onebrc.BraceFormatter{}).Format(&expected, mmap.ProcessChunk(data)); err != nil {
+			t.Fatal(err)
+		}
+		var actual bytes.Buffer
+		if err := impl(path, &actual); err != nil {
+			t.Fatalf("implementation failed: %v\ninput:\n%s", err, data)
+		}
+		if strings.TrimSpace(actual.String()) != strings.TrimSpace(expected.String()) {
+			t.Fatalf("%s\ninput:\n%s", explainOutputs(expected.String(), actual.String()), data)
+		}
+	})
+}
+
+// explainOutputs describes the differences between two brace outputs, or
+// prints both if they cannot be parsed.
+func explainOutputs(expectedOutput, actualOutput string) string {
+	expected, err := Parse(expectedOutput)
+	if err == nil {
+		var actual Output
+		if actual, err = Parse(actualOutput); err == nil {
+			if diffs := Diff(expected, actual); len(diffs) > 0 {
+				return strings.Join(diffs, "\n")
+			}
+		}
+	}
+	return fmt.Sprintf("expected:\n%s\ngot:\n%s", expectedOutput, actualOutput)
+}
+
+// nameAlphabet mixes one to four byte UTF-8 sequences and the characters
+// that are special to the parsers or to the output format.
+var nameAlphabet = []rune("abcXYZ 019.-=,/{}éßøЖ東京🙂")
+
+// Measurements deterministically turns arbitrary bytes into a file following
+// the spec: names of 1 to 100 bytes of valid UTF-8 without ';' or '\n' and
+// temperatures from -99.9 to 99.9 with one fractional digit. A control byte
+// below 0x80 reuses an earlier name, otherwise a new name of up to 16 runes
+// follows. The last line may lack its newline.
+func Measurements(seed []byte) []byte {
+	next := func() byte {
+		if len(seed) == 0 {
+			return 0
+		}
+		b := seed[0]
+		seed = seed[1:]
+		return b
+	}
+
+	var names [][]byte
+	var buf bytes.Buffer
+	for len(seed) > 0 {
+		control := next()
+		var name []byte
+		if control < 0x80 && len(names) > 0 {
+			name = names[int(control)%len(names)]
+		} else {
+			for n := int(control%16) + 1; n > 0 && len(seed) > 0; n-- {
+				r := nameAlphabet[int(next())%len(nameAlphabet)]
+				if len(name)+utf8.RuneLen(r) > 100 {
+					break
+				}
+				name = utf8.AppendRune(name, r)
+			}
+			if len(name) == 0 {
+				name = []byte("x")
+			}
+			names = append(names, name)
+		}
+
+		temp := (int64(next())<<8|int64(next()))%1999 - 999
+		buf.Write(name)
+		buf.WriteByte(';')
+		buf.WriteString(onebrc.FormatTenths(temp))
+		buf.WriteByte('\n')
+	}
+	if len(names) > 0 && names[0][0]%2 == 0 {
+		buf.Truncate(buf.Len() - 1)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,415 @@
+// Package compressed lets every engine read gzip and bzip2 compressed
+// measurements. The format is detected by magic bytes, not by file name, and
+// the input is decompressed straight into the split engine's chunk parser
+// without a temporary file.
+//
+// A gzip file may consist of several members, e.g. as written by pigz or
+// bgzip, or by concatenating gzip files. Members are decompressed in
+// parallel: their start offsets are taken from a sidecar index file, see
+// IndexPath, or else every offset holding a gzip header is tried and the
+// members that decompress without error and tile the file are used. Lines
+// spanning two members are joined afterwards. bzip2 is decompressed
+// sequentially.
+package compressed
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"onebrc"
+	"onebrc/engine/split"
+)
+
+// Format is the compression of an input file.
+type Format int
+
+const (
+	Plain Format = iota
+	Gzip
+	Bzip2
+)
+
+func (f Format) String() string {
+	switch f {
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	}
+	return "plain"
+}
+
+// gzipMagic is the start of every gzip member using deflate.
+var gzipMagic = []byte{0x1f, 0x8b, 8}
+
+// Detect returns the format of the data at the start of r.
+func Detect(r io.ReaderAt) (Format, error) {
+	var magic [4]byte
+	n, err := r.ReadAt(magic[:], 0)
+	if err != nil && err != io.EOF {
+		return Plain, err
+	}
+	switch {
+	case n >= 3 && bytes.Equal(magic[:3], gzipMagic):
+		return Gzip, nil
+	case n >= 4 && string(magic[:3]) == "BZh" && magic[3] >= '1' && magic[3] <= '9':
+		return Bzip2, nil
+	}
+	return Plain, nil
+}
+
+// Engine reads compressed files itself and hands plain ones to the wrapped
+// engine.
+type Engine struct {
+	plain onebrc.Aggregator
+	opts  onebrc.Options
+}
+
+// Wrap returns an engine that aggregates plain files with plain.
+func Wrap(plain onebrc.Aggregator, opts onebrc.Options) onebrc.Aggregator {
+	return &Engine{plain: plain, opts: opts}
+}
+
+func (e *Engine) Aggregate(path string) (onebrc.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	format, err := Detect(f)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case Gzip:
+		st, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		offsets, err := ReadIndex(IndexPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			offsets, err = scanHeaders(f, st.Size())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return aggregateGzip(f, st.Size(), offsets, e.opts)
+	case Bzip2:
+		result := make(onebrc.Result)
+		err := split.ReadBlocks(bzip2.NewReader(bufio.NewReader(f)), func(block []byte) error {
+			return split.ParseBlockWith(block, result, e.opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	f.Close()
+	return e.plain.Aggregate(path)
+}
+
+// IndexPath returns the path of the sidecar index of a gzip file: the file
+// name with ".idx" appended. The index lists the start offset of every member
+// in decimal, one per line.
+func IndexPath(path string) string {
+	return path + ".idx"
+}
+
+// ReadIndex reads the member offsets from an index file.
+func ReadIndex(path string) ([]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int64
+	for _, line := range strings.Fields(string(data)) {
+		offset, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
+}
+
+// WriteIndex writes the member offsets of a gzip file to its index file.
+func WriteIndex(path string, offsets []int64) error {
+	var b strings.Builder
+	for _, offset := range offsets {
+		b.WriteString(strconv.FormatInt(offset, 10))
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(IndexPath(path), []byte(b.String()), 0644)
+}
+
+// Members decompresses the gzip file r sequentially and returns the start
+// offsets of its members, i.e. the content of an index file.
+func Members(r io.Reader) ([]int64, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+	zr, err := gzip.NewReader(cr)
+	if err != nil {
+		return nil, err
+	}
+	offsets := []int64{0}
+	for {
+		zr.Multistream(false)
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			return nil, err
+		}
+		next := cr.n
+		if err := zr.Reset(cr); err == io.EOF {
+			return offsets, nil
+		} else if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, next)
+	}
+}
+
+// scanHeaders returns every offset of r that starts with a plausible gzip
+// header. Besides the real members it may find matches inside compressed data.
+func scanHeaders(r io.ReaderAt, size int64) ([]int64, error) {
+	const blockSize = 4 * 1024 * 1024
+	// a header is 10 bytes, a match is only accepted if the whole header
+	// is inside the block, so consecutive blocks overlap by that much
+	const overlap = 10
+
+	var offsets []int64
+	buf := make([]byte, blockSize+overlap)
+	for from := int64(0); from < size; from += blockSize {
+		n, err := r.ReadAt(buf, from)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		block := buf[:n]
+		for i := 0; ; {
+			match := bytes.Index(block[i:], gzipMagic)
+			if match < 0 {
+				break
+			}
+			i += match
+			if i >= blockSize {
+				break // found again by the next block
+			}
+			// the flags' reserved bits must be zero
+			if i+overlap <= len(block) && block[i+3]&0xe0 == 0 {
+				offsets = append(offsets, from+int64(i))
+			}
+			i++
+		}
+	}
+	return offsets, nil
+}
+
+// countingReader counts the bytes consumed by the gzip reader. It implements
+// io.ByteReader, so the reader does not buffer beyond the end of a member.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// member is one decompressed gzip member. Lines spanning members are
+// completed after all members are known, so the fragments before the first
+// and after the last newline are kept apart.
+type member struct {
+	start, end int64
+	head, tail []byte
+	newline    bool // whether head and tail are separate fragments
+	result     onebrc.Result
+	counts     split.Counts // flushed once the member is on the chain
+	err        error
+}
+
+// decoder decompresses members, reusing its buffers and gzip reader.
+type decoder struct {
+	opts onebrc.Options
+	buf  []byte
+	br   *bufio.Reader
+	zr   *gzip.Reader
+}
+
+// decode decompresses and parses the member starting at offset.
+func (d *decoder) decode(r io.ReaderAt, size, offset int64) *member {
+	m := &member{start: offset, result: make(onebrc.Result)}
+	section := io.NewSectionReader(r, offset, size-offset)
+	if d.br == nil {
+		d.buf = make([]byte, 4*1024*1024)
+		d.br = bufio.NewReader(section)
+	} else {
+		d.br.Reset(section)
+	}
+	cr := &countingReader{r: d.br}
+	var err error
+	if d.zr == nil {
+		d.zr, err = gzip.NewReader(cr)
+	} else {
+		err = d.zr.Reset(cr)
+	}
+	if err != nil {
+		m.err = err
+		return m
+	}
+	d.zr.Multistream(false)
+	zr, buf := split.Strict(d.zr), d.buf
+
+	filled := 0
+	for {
+		n, err := io.ReadFull(zr, buf[filled:])
+		filled += n
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			m.err = err
+			return m
+		}
+
+		block := buf[:filled]
+		last := bytes.LastIndexByte(block, '\n')
+		if last >= 0 {
+			if !m.newline {
+				first := bytes.IndexByte(block, '\n')
+				m.head = append(m.head, block[:first]...)
+				m.newline = true
+				block = block[first+1:]
+				last -= first + 1
+			}
+			if err := split.ParseBlockCounting(block[:last+1], m.result, d.opts, &m.counts); err != nil {
+				m.err = err
+				return m
+			}
+			filled = copy(buf, block[last+1:])
+		} else if !eof && filled == len(buf) {
+			// a line without newline filling the buffer, no further
+			// newline can be in a fragment that long
+			m.head = append(m.head, block...)
+			filled = 0
+		}
+
+		if eof {
+			if m.newline {
+				m.tail = append([]byte(nil), buf[:filled]...)
+			} else {
+				m.head = append(m.head, buf[:filled]...)
+			}
+			m.end = offset + cr.n
+			return m
+		}
+	}
+}
+
+func aggregateGzip(r io.ReaderAt, size int64, offsets []int64, opts onebrc.Options) (onebrc.Result, error) {
+	workers := opts.NumWorkers()
+	offsetsCh := make(chan int64)
+	go func() {
+		for _, offset := range offsets {
+			offsetsCh <- offset
+		}
+		close(offsetsCh)
+	}()
+
+	var (
+		mu      sync.Mutex
+		members []*member
+		wg      sync.WaitGroup
+	)
+	results := make([]onebrc.Result, workers)
+	for i := range results {
+		results[i] = make(onebrc.Result)
+		wg.Add(1)
+		go func(result onebrc.Result) {
+			defer wg.Done()
+			d := decoder{opts: opts}
+			for offset := range offsetsCh {
+				m := d.decode(r, size, offset)
+				if m.err != nil {
+					// a false match or a broken member
+					m.head, m.tail = nil, nil
+				} else {
+					result.Merge(m.result)
+				}
+				m.result = nil
+				mu.Lock()
+				members = append(members, m)
+				mu.Unlock()
+			}
+		}(results[i])
+	}
+	wg.Wait()
+
+	// follow the members from the start to the end of the file
+	byStart := make(map[int64]*member, len(members))
+	for _, m := range members {
+		byStart[m.start] = m
+	}
+	var chain []*member
+	for pos := int64(0); pos < size; {
+		m := byStart[pos]
+		if m == nil {
+			return nil, fmt.Errorf("no gzip member starts at offset %d", pos)
+		}
+		if m.err != nil {
+			return nil, fmt.Errorf("gzip member at offset %d: %w", pos, m.err)
+		}
+		chain = append(chain, m)
+		pos = m.end
+	}
+	// a member decoded without error but not on the chain would have been
+	// counted too
+	valid := 0
+	for _, m := range members {
+		if m.err == nil {
+			valid++
+		}
+	}
+	if valid != len(chain) {
+		return nil, fmt.Errorf("%d gzip members overlap", valid-len(chain))
+	}
+	// false matches parse garbage until they fail, only the chain is counted
+	for _, m := range chain {
+		m.counts.Flush(opts)
+	}
+
+	result := results[0]
+	for _, r := range results[1:] {
+		result.Merge(r)
+	}
+
+	var carry []byte
+	for _, m := range chain {
+		carry = append(carry, m.head...)
+		if m.newline {
+			if err := split.ParseBlockWith(carry, result, opts); err != nil {
+				return nil, err
+			}
+			carry = append(carry[:0], m.tail...)
+		}
+	}
+	if err := split.ParseBlockWith(carry, result, opts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,269 @@
+package compressed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"onebrc"
+	"onebrc/conformance"
+	"onebrc/engine/split"
+)
+
+const samplesDir = "../../" + conformance.SamplesDir
+
+// gzipMembers compresses data as one member per memberSize bytes and returns
+// the compressed data with the member offsets.
+func gzipMembers(t *testing.T, data []byte, memberSize, level int) ([]byte, []int64) {
+	t.Helper()
+
+	var b bytes.Buffer
+	var offsets []int64
+	for len(data) > 0 || len(offsets) == 0 {
+		n := min(memberSize, len(data))
+		offsets = append(offsets, int64(b.Len()))
+		zw, err := gzip.NewWriterLevel(&b, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(data[:n])
+		zw.Close()
+		data = data[n:]
+	}
+	return b.Bytes(), offsets
+}
+
+// compressSamples writes every sample gzip compressed to a new directory,
+// keeping the file names.
+func compressSamples(t *testing.T, memberSize int, index bool) string {
+	t.Helper()
+
+	samples, err := conformance.Samples(samplesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, s := range samples {
+		data, err := os.ReadFile(s.Input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile(s.Expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compressed, offsets := gzipMembers(t, data, memberSize, gzip.DefaultCompression)
+		path := filepath.Join(dir, filepath.Base(s.Input))
+		if err := os.WriteFile(path, compressed, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(s.Expected)), expected, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if index {
+			if err := WriteIndex(path, offsets); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestGzipConformance(t *testing.T) {
+	for _, tc := range []struct {
+		memberSize int
+		index      bool
+	}{
+		{1 << 30, false},
+		{61, false},
+		{61, true},
+		{4096, false},
+	} {
+		t.Run(fmt.Sprintf("member=%d,index=%v", tc.memberSize, tc.index), func(t *testing.T) {
+			dir := compressSamples(t, tc.memberSize, tc.index)
+			conformance.RunAggregator(t, dir, Wrap(nil, onebrc.Options{Workers: 3}))
+		})
+	}
+}
+
+func TestBzip2(t *testing.T) {
+	expected := make(onebrc.Result)
+	data, err := os.ReadFile(samplesDir + "/measurements-20.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := split.ParseBlock(data, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Wrap(nil, onebrc.Options{}).Aggregate("testdata/measurements-20.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, expected, result)
+}
+
+func TestTruncated(t *testing.T) {
+	bz, err := os.ReadFile("testdata/measurements-20.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(strings.Repeat("Hamburg;12.0\nBulawayo;8.9\n", 100))
+	gz, _ := gzipMembers(t, data, len(data), gzip.BestSpeed)
+	for name, compressed := range map[string][]byte{
+		"half of a bzip2 stream":  bz[:len(bz)/2],
+		"gzip without trailer":    gz[:len(gz)-8],
+		"gzip in the compression": gz[:len(gz)/2],
+	} {
+		path := filepath.Join(t.TempDir(), "measurements.txt") // detected by content
+		if err := os.WriteFile(path, compressed, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Wrap(nil, onebrc.Options{Workers: 2}).Aggregate(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPlainUsesWrappedEngine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte("a;1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Wrap(split.New(onebrc.Options{}), onebrc.Options{}).Aggregate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, onebrc.Result{"a": {Min: 10, Max: 10, Sum: 10, Count: 1, SumSquares: 100}}, result)
+}
+
+func TestDetect(t *testing.T) {
+	gz, _ := gzipMembers(t, []byte("a;1.0\n"), 100, gzip.DefaultCompression)
+	for _, tc := range []struct {
+		data     []byte
+		expected Format
+	}{
+		{gz, Gzip},
+		{[]byte("BZh91AY&SY"), Bzip2},
+		{[]byte("BZh"), Plain},
+		{[]byte("BZhx;1.0\n"), Plain},
+		{[]byte("a;1.0\n"), Plain},
+		{nil, Plain},
+	} {
+		format, err := Detect(bytes.NewReader(tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.data, tc.expected, format)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	data := []byte(strings.Repeat("Hamburg;12.0\nBulawayo;8.9\n", 100))
+	compressed, expected := gzipMembers(t, data, 300, gzip.BestSpeed)
+
+	offsets, err := Members(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(offsets) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v, got %v", expected, offsets)
+	}
+}
+
+// TestFalseHeaderMatch stores a gzip header inside uncompressed deflate data,
+// so scanning finds an offset that is not a member.
+func TestFalseHeaderMatch(t *testing.T) {
+	data := []byte("\x1f\x8b\x08\x00fake;1.0\nHamburg;12.0\n")
+	compressed, _ := gzipMembers(t, bytes.Repeat(data, 3), 2*len(data), gzip.NoCompression)
+
+	offsets, err := scanHeaders(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) <= 2 {
+		t.Fatalf("Expected false matches besides the 2 members, got %v", offsets)
+	}
+
+	path := filepath.Join(t.TempDir(), "measurements.txt.gz")
+	if err := os.WriteFile(path, compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Wrap(nil, onebrc.Options{Workers: 2}).Aggregate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, onebrc.Result{
+		"\x1f\x8b\x08\x00fake": {Min: 10, Max: 10, Sum: 30, Count: 3, SumSquares: 300},
+		"Hamburg":              {Min: 120, Max: 120, Sum: 360, Count: 3, SumSquares: 43200},
+	}, result)
+}
+
+// TestFalseMatchNotCounted hides a corrupt member in the extra field of a
+// real one. It decodes to more than a buffer before failing, so its rows are
+// parsed, but not counted by the filter.
+func TestFalseMatchNotCounted(t *testing.T) {
+	hidden, _ := gzipMembers(t, []byte(strings.Repeat("Hamburg;12.0\n", 400000)), 1<<30, gzip.BestCompression)
+	hidden[len(hidden)-8] ^= 0xff // the CRC
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Extra = hidden
+	zw.Write([]byte(strings.Repeat("Hamburg;12.0\nBulawayo;8.9\n", 100)))
+	zw.Close()
+	offsets, err := scanHeaders(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) < 2 {
+		t.Fatalf("Expected the hidden member to match, got %v", offsets)
+	}
+
+	filter := onebrc.NewFilter()
+	filter.Stations = map[string]bool{"Bulawayo": true}
+	result, err := aggregateGzip(bytes.NewReader(b.Bytes()), int64(b.Len()), offsets, onebrc.Options{Workers: 2, Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEqual(t, onebrc.Result{
+		"Bulawayo": {Min: 89, Max: 89, Sum: 8900, Count: 100, SumSquares: 792100},
+	}, result)
+	if stationRows, tempRows := filter.Rejected(); stationRows != 100 || tempRows != 0 {
+		t.Errorf("Expected 100 rows rejected by station, got %d and %d by temperature", stationRows, tempRows)
+	}
+}
+
+func TestCorruptMember(t *testing.T) {
+	data := []byte(strings.Repeat("Hamburg;12.0\nBulawayo;8.9\n", 100))
+	compressed, offsets := gzipMembers(t, data, 1000, gzip.BestSpeed)
+	// flip a byte of the second member's CRC
+	compressed[offsets[2]-8] ^= 0xff
+
+	path := filepath.Join(t.TempDir(), "measurements.txt.gz")
+	if err := os.WriteFile(path, compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Wrap(nil, onebrc.Options{Workers: 2}).Aggregate(path); err == nil {
+		t.Fatal("Expected an error for a corrupt member")
+	}
+}
+
+func checkEqual(t *testing.T, expected, actual onebrc.Result) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d stations, got %d", len(expected), len(actual))
+	}
+	for name, e := range expected {
+		if a := actual[name]; a == nil || *a != *e {
+			t.Fatalf("%q: expected %+v, got %+v", name, *e, a)
+		}
+	}
+}
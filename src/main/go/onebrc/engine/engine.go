@@ -0,0 +1,42 @@
+// Package engine selects one of the aggregation engines by name. Every engine
+// also reads gzip and bzip2 compressed files, see package compressed.
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"onebrc"
+	"onebrc/engine/compressed"
+	"onebrc/engine/mmap"
+	"onebrc/engine/readat"
+	"onebrc/engine/split"
+)
+
+// Default is the engine used when none is selected.
+const Default = "mmap"
+
+var engines = map[string]func(onebrc.Options) onebrc.Aggregator{
+	"mmap":   mmap.New,
+	"readat": readat.New,
+	"split":  split.New,
+}
+
+// New returns the engine registered as name.
+func New(name string, opts onebrc.Options) (onebrc.Aggregator, error) {
+	newEngine, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown engine %q, available: %v", name, Names())
+	}
+	return compressed.Wrap(newEngine(opts), opts), nil
+}
+
+// Names returns the names of all engines in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
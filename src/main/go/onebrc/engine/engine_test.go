@@ -0,0 +1,341 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"onebrc"
+	"onebrc/conformance"
+	"onebrc/engine/split"
+	"onebrc/gen"
+)
+
+const samplesDir = "../" + conformance.SamplesDir
+
+func TestEnginesConformance(t *testing.T) {
+	for _, name := range Names() {
+		for _, opts := range []onebrc.Options{
+			{},
+			{Workers: 3, ChunkSize: 64},
+			{Workers: 8, ChunkSize: 7},
+		} {
+			aggregator, err := New(name, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Run(fmt.Sprintf("%s/workers=%d,chunk=%d", name, opts.Workers, opts.ChunkSize), func(t *testing.T) {
+				conformance.RunAggregator(t, samplesDir, aggregator)
+			})
+		}
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	if _, err := New("nope", onebrc.Options{}); err == nil {
+		t.Error("expected an error for an unknown engine")
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		numParts int
+	}{
+		{"", 8},
+		{"a;1.0\nb;2.0\nc;-3.0", 2},
+		{strings.Repeat("a;1.0\n", 9), 8}, // 54 bytes, 8 nominal parts of 6.75
+		{strings.Repeat(strings.Repeat("x", 10000)+";1.0\nb;2.0\n", 5), 8},
+	} {
+		parts, err := split.SplitFile(strings.NewReader(tc.data), int64(len(tc.data)), tc.numParts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) > tc.numParts {
+			t.Errorf("%.20q: expected at most %d parts, got %d", tc.data, tc.numParts, len(parts))
+		}
+		var offset int64
+		for _, p := range parts {
+			if p.Offset != offset || p.Size <= 0 {
+				t.Fatalf("%.20q: part %+v does not start at %d", tc.data, p, offset)
+			}
+			offset += p.Size
+			if offset < int64(len(tc.data)) && tc.data[offset-1] != '\n' {
+				t.Errorf("%.20q: part %+v does not end at a line boundary", tc.data, p)
+			}
+		}
+		if offset != int64(len(tc.data)) {
+			t.Errorf("%.20q: parts cover %d bytes, expected %d", tc.data, offset, len(tc.data))
+		}
+	}
+}
+
+// loadStations loads the stations the generated test files are made of.
+func loadStations(t *testing.T) []gen.Station {
+	t.Helper()
+
+	stations, err := gen.LoadStations("../../../../../data/weather_stations.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stations
+}
+
+// generate returns rows measurements written by g.
+func generate(t *testing.T, g *gen.Generator, rows int64) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := g.Write(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// forEachEngine aggregates data with every engine, each with the options
+// returned by newOpts, so filters and aliases count per engine, and hands
+// them to check with the result.
+func forEachEngine(t *testing.T, data []byte, newOpts func() onebrc.Options, check func(name string, opts onebrc.Options, result onebrc.Result)) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range Names() {
+		opts := newOpts()
+		opts.Workers, opts.ChunkSize = 4, 4096
+		aggregator, err := New(name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := aggregator.Aggregate(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		check(name, opts, result)
+	}
+}
+
+// eachLine calls f with the station and temperature of every line of data.
+func eachLine(data []byte, f func(station []byte, temp int64)) {
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		semi := bytes.IndexByte(line, ';')
+		temp, _ := split.ParseTenths(line[semi+1:])
+		f(line[:semi], temp)
+	}
+}
+
+func TestEnginesAgreeOnGeneratedModes(t *testing.T) {
+	stations := loadStations(t)
+
+	for _, mode := range gen.Modes {
+		t.Run(mode, func(t *testing.T) {
+			g := &gen.Generator{Stations: stations[:gen.MaxStations], StdDev: 10, Seed: 1, Workers: 2}
+			if mode == "fnv-collide" || mode == "long-utf8" {
+				g.Stations = g.Stations[:2000]
+			}
+			if err := g.Configure(mode); err != nil {
+				t.Fatal(err)
+			}
+
+			var reference onebrc.Result
+			forEachEngine(t, generate(t, g, 50_000), func() onebrc.Options { return onebrc.Options{} }, func(name string, _ onebrc.Options, result onebrc.Result) {
+				if reference == nil {
+					reference = result
+				} else if diff := diffResults(reference, result); diff != "" {
+					t.Errorf("%s disagrees: %s", name, diff)
+				}
+			})
+			if rows := reference.Rows(); rows != 50_000 {
+				t.Errorf("Wrong rows, expected: 50000, got: %d", rows)
+			}
+		})
+	}
+}
+
+// TestEnginesQuantiles checks the per-station digests of every engine against
+// the exact quantiles of a generated file.
+func TestEnginesQuantiles(t *testing.T) {
+	g := &gen.Generator{Stations: loadStations(t)[:20], StdDev: 10, Seed: 1, Workers: 2}
+	data := generate(t, g, 100_000)
+
+	exact := make(map[string][]float64)
+	eachLine(data, func(station []byte, temp int64) {
+		exact[string(station)] = append(exact[string(station)], float64(temp)/10)
+	})
+	for _, values := range exact {
+		sort.Float64s(values)
+	}
+
+	forEachEngine(t, data, func() onebrc.Options { return onebrc.Options{Compression: 100} }, func(name string, _ onebrc.Options, result onebrc.Result) {
+		for station, values := range exact {
+			s := result[station]
+			if s == nil || s.Digest == nil {
+				t.Fatalf("%s: %s: missing digest", name, station)
+			}
+			if c := s.Digest.Count(); c != float64(len(values)) {
+				t.Errorf("%s: %s: digest count %v, expected %d", name, station, c, len(values))
+			}
+			for _, q := range []float64{0.01, 0.25, 0.5, 0.75, 0.99} {
+				estimate := s.Quantile(q)
+				// ranks of the values equal to the estimate, rounded
+				// to tenths like the input
+				below := float64(sort.SearchFloat64s(values, estimate-0.05)) / float64(len(values))
+				atOrBelow := float64(sort.SearchFloat64s(values, estimate+0.05)) / float64(len(values))
+				if q < below-0.01 || q > atOrBelow+0.01 {
+					t.Errorf("%s: %s: p%v estimate %.2f has ranks %.4f..%.4f", name, station, q*100, estimate, below, atOrBelow)
+				}
+			}
+		}
+	})
+}
+
+// TestEnginesFilter checks that every engine applies the same filters and
+// counts the same rejected rows as filtering the parsed lines afterwards.
+func TestEnginesFilter(t *testing.T) {
+	stations := loadStations(t)
+	g := &gen.Generator{Stations: stations[:400], StdDev: 10, Seed: 1, Workers: 2}
+	data := generate(t, g, 50_000)
+
+	filters := map[string]func() *onebrc.Filter{
+		"include": func() *onebrc.Filter {
+			f := onebrc.NewFilter()
+			f.Include = regexp.MustCompile("^[A-F]")
+			return f
+		},
+		"exclude-list": func() *onebrc.Filter {
+			f := onebrc.NewFilter()
+			f.ExcludeStations = map[string]bool{stations[0].Name: true, stations[1].Name: true}
+			f.Exclude = regexp.MustCompile("a$")
+			return f
+		},
+		"stations-window": func() *onebrc.Filter {
+			f := onebrc.NewFilter()
+			f.Stations = map[string]bool{stations[2].Name: true, stations[3].Name: true, "Nowhere": true}
+			f.Min, f.Max = -50, 250
+			return f
+		},
+		"window": func() *onebrc.Filter {
+			f := onebrc.NewFilter()
+			f.Min, f.Max = 0, 0
+			return f
+		},
+	}
+
+	for filterName, newFilter := range filters {
+		t.Run(filterName, func(t *testing.T) {
+			// filter the parsed lines
+			reference := newFilter()
+			expected := make(onebrc.Result)
+			var stationRows, tempRows int64
+			eachLine(data, func(station []byte, temp int64) {
+				switch {
+				case !reference.AcceptStation(station):
+					stationRows++
+				case !reference.AcceptTemp(temp):
+					tempRows++
+				default:
+					if expected[string(station)] == nil {
+						expected[string(station)] = &onebrc.Stats{}
+					}
+					expected[string(station)].Add(temp)
+				}
+			})
+			if stationRows+tempRows == 0 {
+				t.Fatal("Filter rejects nothing")
+			}
+
+			forEachEngine(t, data, func() onebrc.Options { return onebrc.Options{Filter: newFilter()} }, func(name string, opts onebrc.Options, result onebrc.Result) {
+				if diff := diffResults(expected, result); diff != "" {
+					t.Errorf("%s: %s", name, diff)
+				}
+				if s, r := opts.Filter.Rejected(); s != stationRows || r != tempRows {
+					t.Errorf("%s: rejected %d rows by station and %d by temperature, expected %d and %d", name, s, r, stationRows, tempRows)
+				}
+			})
+		})
+	}
+}
+
+// TestEnginesAliases checks that every engine folds respelled station names
+// into their canonical ones before filtering and reports the same variants.
+func TestEnginesAliases(t *testing.T) {
+	stations := loadStations(t)
+	g := &gen.Generator{Stations: stations[:400], StdDev: 10, Seed: 1, Workers: 2}
+	generated := generate(t, g, 50_000)
+
+	newAliases := func() *onebrc.Aliases {
+		a := &onebrc.Aliases{TrimSpace: true, FoldCase: true}
+		for _, s := range stations[:400] {
+			if err := a.Add(s.Name, s.Name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return a
+	}
+	include := regexp.MustCompile("^[A-F]")
+
+	// respell every other row and aggregate the canonical rows
+	reference := newAliases()
+	expected := make(onebrc.Result)
+	expectedFolded := make(map[string]int64)
+	var data bytes.Buffer
+	i := 0
+	eachLine(generated, func(station []byte, temp int64) {
+		name := string(station)
+		switch i % 4 {
+		case 1:
+			name = strings.ToUpper(name)
+		case 3:
+			name = " " + strings.ToLower(name) + "\t"
+		}
+		i++
+		fmt.Fprintf(&data, "%s;%s\n", name, onebrc.FormatTenths(temp))
+
+		canonical := reference.Canonical([]byte(name))
+		if canonical != string(station) {
+			t.Fatalf("%q is not an alias of %q", name, station)
+		}
+		if !include.MatchString(canonical) {
+			return
+		}
+		if name != canonical {
+			expectedFolded[canonical+"|"+name]++
+		}
+		if expected[canonical] == nil {
+			expected[canonical] = &onebrc.Stats{}
+		}
+		expected[canonical].Add(temp)
+	})
+	if len(expectedFolded) == 0 {
+		t.Fatal("Nothing respelled")
+	}
+
+	newOpts := func() onebrc.Options {
+		filter := onebrc.NewFilter()
+		filter.Include = include
+		return onebrc.Options{Filter: filter, Aliases: newAliases()}
+	}
+	forEachEngine(t, data.Bytes(), newOpts, func(name string, opts onebrc.Options, result onebrc.Result) {
+		if diff := diffResults(expected, result); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+
+		folded := make(map[string]int64)
+		for canonical, rows := range opts.Aliases.Folded() {
+			for raw, n := range rows {
+				folded[canonical+"|"+raw] = n
+			}
+		}
+		if !maps.Equal(folded, expectedFolded) {
+			t.Errorf("%s: folded %d variants, expected %d", name, len(folded), len(expectedFolded))
+		}
+	})
+}
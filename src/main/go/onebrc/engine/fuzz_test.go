@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"onebrc"
+	"onebrc/conformance"
+)
+
+// FuzzEngines runs every engine on the same spec-valid measurements file, see
+// conformance.Measurements, and fails on any disagreement. The fuzzer
+// minimizes failing inputs and stores them in testdata/fuzz/FuzzEngines, from
+// where go test replays them. The original implementations are fuzzed the same
+// way by conformance.Fuzz.
+//
+//	go test -fuzz FuzzEngines ./engine
+func FuzzEngines(f *testing.F) {
+	f.Add(conformance.Seeds[0], uint8(3), uint16(7))
+	f.Add(conformance.Seeds[1], uint8(8), uint16(1))
+	f.Add(conformance.Seeds[2], uint8(1), uint16(300))
+
+	f.Fuzz(func(t *testing.T, seed []byte, workers uint8, chunkSize uint16) {
+		if len(seed) > conformance.MaxSeedLen {
+			// tiny chunks make the chunked engines slow on large files
+			seed = seed[:conformance.MaxSeedLen]
+		}
+		data := conformance.Measurements(seed)
+		path := filepath.Join(t.TempDir(), "measurements.txt")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := onebrc.Options{Workers: 1 + int(workers%16), ChunkSize: 8 + int(chunkSize%512)}
+
+		var reference onebrc.Result
+		var referenceName string
+		for _, name := range Names() {
+			aggregator, err := New(name, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := aggregator.Aggregate(path)
+			if err != nil {
+				t.Fatalf("%s failed on:\n%s\nerror: %v", name, data, err)
+			}
+			if reference == nil {
+				reference, referenceName = result, name
+				continue
+			}
+			if diff := diffResults(reference, result); diff != "" {
+				t.Fatalf("%s and %s disagree with %+v: %s\ninput:\n%s", referenceName, name, opts, diff, data)
+			}
+		}
+	})
+}
+
+func diffResults(expected, actual onebrc.Result) string {
+	for name, e := range expected {
+		a, ok := actual[name]
+		if !ok {
+			return fmt.Sprintf("station %q missing", name)
+		}
+		if *a != *e {
+			return fmt.Sprintf("station %q: %+v != %+v", name, *e, *a)
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			return fmt.Sprintf("unexpected station %q", name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,291 @@
+// Package mmap is the engine derived from AlexanderYastrebov's
+// implementation: the file is memory mapped, split into one chunk per worker
+// and every chunk is parsed into a fixed size linear probe table.
+package mmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"onebrc"
+)
+
+type Engine struct {
+	opts onebrc.Options
+}
+
+func New(opts onebrc.Options) onebrc.Aggregator {
+	return &Engine{opts: opts}
+}
+
+func (e *Engine) Aggregate(path string) (onebrc.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		return onebrc.Result{}, nil
+	}
+	if size < 0 || size != int64(int(size)) {
+		return nil, fmt.Errorf("invalid file size: %d", size)
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+
+	result := Process(data, e.opts)
+
+	if err := syscall.Munmap(data); err != nil {
+		return nil, fmt.Errorf("munmap: %w", err)
+	}
+	return result, nil
+}
+
+// Process aggregates data using opts.NumWorkers() concurrent parsers. Station
+// names are copied, so the result does not reference data.
+func Process(data []byte, opts onebrc.Options) onebrc.Result {
+	chunks := Chunks(data, opts.NumWorkers())
+
+	var wg sync.WaitGroup
+	wg.Add(len(chunks))
+
+	results := make([]onebrc.Result, len(chunks))
+	start := 0
+	for i, chunk := range chunks {
+		go func(data []byte, i int) {
+			results[i] = ProcessChunkWith(data, opts)
+			wg.Done()
+		}(data[start:chunk], i)
+		start = chunk
+	}
+	wg.Wait()
+
+	measurements := make(onebrc.Result)
+	for _, r := range results {
+		measurements.Merge(r)
+	}
+	return measurements
+}
+
+// Chunks splits data into about nChunks newline aligned chunks and returns
+// their end offsets.
+func Chunks(data []byte, nChunks int) []int {
+	chunkSize := len(data) / max(nChunks, 1)
+	if chunkSize == 0 {
+		chunkSize = len(data)
+	}
+
+	chunks := make([]int, 0, nChunks)
+	offset := 0
+	for offset < len(data) {
+		offset += chunkSize
+		if offset >= len(data) {
+			chunks = append(chunks, len(data))
+			break
+		}
+
+		nlPos := bytes.IndexByte(data[offset:], '\n')
+		if nlPos == -1 {
+			chunks = append(chunks, len(data))
+			break
+		} else {
+			offset += nlPos + 1
+			chunks = append(chunks, offset)
+		}
+	}
+	return chunks
+}
+
+// ProcessChunk aggregates the complete lines of data. It assumes valid input,
+// the last line may omit the trailing newline.
+func ProcessChunk(data []byte) onebrc.Result {
+	return ProcessChunkWith(data, onebrc.Options{})
+}
+
+// MaxNameLength is the longest station name ProcessChunk reads, in bytes.
+const MaxNameLength = 100
+
+// CheckLine returns an error unless line, without its newline, is one that
+// ProcessChunk reads correctly: a name of 1 to MaxNameLength bytes, a ';' and
+// a temperature of the form -?\d{1,2}\.\d.
+func CheckLine(line []byte) error {
+	semi := bytes.IndexByte(line, ';')
+	switch {
+	case semi < 0:
+		return errors.New("missing ';'")
+	case semi == 0:
+		return errors.New("empty station name")
+	case semi > MaxNameLength:
+		return fmt.Errorf("station name longer than %d bytes", MaxNameLength)
+	}
+
+	temp := line[semi+1:]
+	digits := temp
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) != 3 && len(digits) != 4 || digits[len(digits)-2] != '.' {
+		return fmt.Errorf("invalid temperature %q", temp)
+	}
+	for i, c := range digits {
+		if i != len(digits)-2 && (c < '0' || c > '9') {
+			return fmt.Errorf("invalid temperature %q", temp)
+		}
+	}
+	return nil
+}
+
+// ProcessChunkWith is ProcessChunk with a digest per station if
+// opts.Compression is set, the rows selected by opts.Filter and the station
+// names folded by opts.Aliases.
+func ProcessChunkWith(data []byte, opts onebrc.Options) onebrc.Result {
+	// Use fixed size linear probe lookup table
+	const (
+		// use power of 2 for fast modulo calculation,
+		// should be larger than max number of keys which is 10_000
+		entriesSize = 1 << 14
+
+		// use FNV-1a hash
+		fnv1aOffset64 = 14695981039346656037
+		fnv1aPrime64  = 1099511628211
+	)
+
+	type entry struct {
+		m        onebrc.Stats
+		hash     uint64
+		vlen     int
+		excluded bool            // by opts.Filter
+		variant  *onebrc.Variant // by opts.Aliases
+		value    [128]byte       // use power of 2 > 100 for alignment
+	}
+	entries := make([]entry, entriesSize)
+	entriesCount := 0
+	filter := opts.Filter
+	aliases := opts.Aliases
+	var stationRows, tempRows int64
+
+	// keep short and inlinable
+	getEntry := func(hash uint64, value []byte) *entry {
+		i := hash & uint64(entriesSize-1)
+		entry := &entries[i]
+
+		// bytes.Equal could be commented to speedup assuming no hash collisions
+		for entry.vlen > 0 && !(entry.hash == hash && bytes.Equal(entry.value[:entry.vlen], value)) {
+			i = (i + 1) & uint64(entriesSize-1)
+			entry = &entries[i]
+		}
+
+		if entry.vlen == 0 {
+			entry.hash = hash
+			entry.vlen = copy(entry.value[:], value)
+			entry.m.Digest = opts.NewDigest()
+			name := value
+			if aliases != nil {
+				entry.variant = &onebrc.Variant{Canonical: aliases.Canonical(value)}
+				name = entry.variant.Bytes()
+			}
+			entry.excluded = filter != nil && !filter.AcceptStation(name)
+			entriesCount++
+		}
+		return entry
+	}
+
+	for len(data) > 0 {
+
+		idHash := uint64(fnv1aOffset64)
+		semiPos := 0
+		for i, b := range data {
+			if b == ';' {
+				semiPos = i
+				break
+			}
+
+			// calculate FNV-1a hash
+			idHash ^= uint64(b)
+			idHash *= fnv1aPrime64
+		}
+
+		idData := data[:semiPos]
+
+		data = data[semiPos+1:]
+
+		var temp int64
+		// parseNumber
+		{
+			negative := data[0] == '-'
+			if negative {
+				data = data[1:]
+			}
+
+			if len(data) < 4 || data[1] == '.' {
+				// 1.2\n
+				temp = int64(data[0])*10 + int64(data[2]) - '0'*(10+1)
+				data = data[min(4, len(data)):]
+				// 12.3\n
+			} else {
+				temp = int64(data[0])*100 + int64(data[1])*10 + int64(data[3]) - '0'*(100+10+1)
+				data = data[min(5, len(data)):]
+			}
+
+			if negative {
+				temp = -temp
+			}
+		}
+
+		e := getEntry(idHash, idData)
+		if filter != nil {
+			if e.excluded {
+				stationRows++
+				continue
+			}
+			if !filter.AcceptTemp(temp) {
+				tempRows++
+				continue
+			}
+		}
+		e.m.Add(temp)
+	}
+	if filter != nil {
+		filter.Count(stationRows, tempRows)
+	}
+
+	result := make(onebrc.Result, entriesCount)
+	var variants onebrc.Variants
+	if aliases != nil {
+		variants = make(onebrc.Variants)
+		defer aliases.Count(variants)
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.m.Count == 0 {
+			continue
+		}
+		if entry.variant == nil {
+			result[string(entry.value[:entry.vlen])] = &entry.m
+			continue
+		}
+		// variants of a station are merged into the canonical one
+		entry.variant.Rows = entry.m.Count
+		variants[string(entry.value[:entry.vlen])] = entry.variant
+		if s := result[entry.variant.Canonical]; s != nil {
+			s.Merge(&entry.m)
+		} else {
+			result[entry.variant.Canonical] = &entry.m
+		}
+	}
+	return result
+}
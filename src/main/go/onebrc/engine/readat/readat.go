@@ -0,0 +1,278 @@
+// Package readat is the engine derived from elh's implementation: a pool of
+// workers reads fixed size chunks with ReadAt, each parser reading past its
+// chunk to the end of the last line.
+package readat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"onebrc"
+)
+
+const (
+	maxNameLen = 100
+	maxNameNum = 10000
+
+	// tuned for a 2023 Macbook M2 Pro
+	DefaultChunkSize = 64 * 1024 * 1024
+)
+
+type Engine struct {
+	opts onebrc.Options
+}
+
+func New(opts onebrc.Options) onebrc.Aggregator {
+	return &Engine{opts: opts}
+}
+
+// Aggregate reads the file in chunks and parses them concurrently. N parsers
+// work off of a chunk offset chan and send results on an output chan. The
+// results are merged into a single result.
+func (e *Engine) Aggregate(path string) (onebrc.Result, error) {
+	numParsers := e.opts.NumWorkers()
+	parseChunkSize := e.opts.ChunkSize
+	if parseChunkSize <= 0 {
+		parseChunkSize = DefaultChunkSize
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s file: %w", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s file: %w", path, err)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(numParsers)
+
+	// buffered to not block on merging
+	chunkOffsetCh := make(chan int64, numParsers)
+	chunkStatsCh := make(chan onebrc.Result, numParsers)
+	errCh := make(chan error, numParsers)
+
+	go func() {
+		for i := int64(0); i < info.Size(); i += int64(parseChunkSize) {
+			chunkOffsetCh <- i
+		}
+		close(chunkOffsetCh)
+	}()
+
+	for i := 0; i < numParsers; i++ {
+		// WARN: w/ extra padding for line overflow. Each chunk should be read past
+		// the intended size to the next new line. 128 bytes should be enough for
+		// a max 100 byte name + the float value.
+		buf := make([]byte, parseChunkSize+128)
+		go func() {
+			defer wg.Done()
+			for chunkOffset := range chunkOffsetCh {
+				stats, err := ParseAt(f, buf, chunkOffset, parseChunkSize, e.opts)
+				if err != nil {
+					errCh <- err
+					// drain so the producer does not block
+					for range chunkOffsetCh {
+					}
+					return
+				}
+				chunkStatsCh <- stats
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(chunkStatsCh)
+	}()
+
+	mergedStats := make(onebrc.Result, maxNameNum)
+	for chunkStats := range chunkStatsCh {
+		mergedStats.Merge(chunkStats)
+	}
+
+	select {
+	case err := <-errCh:
+		return nil, err
+	default:
+		return mergedStats, nil
+	}
+}
+
+// ParseAt parses the lines starting in [offset, offset+size) of f. size is the
+// intended number of bytes to parse. buffer should be longer than size because
+// we need to continue reading until the end of the line in order to properly
+// segment the entire file and not miss any data. Stations get a digest if
+// opts.Compression is set.
+func ParseAt(f io.ReaderAt, buf []byte, offset int64, size int, opts onebrc.Options) (onebrc.Result, error) {
+	skipFirst := offset != 0
+	if skipFirst {
+		// start one byte early so that a chunk starting right at a line
+		// boundary skips only the preceding newline and not its first line
+		offset--
+		size++
+	}
+	n, err := f.ReadAt(buf, offset) // load the buffer
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return ParseBuffer(buf[:n], skipFirst, size, opts), nil
+}
+
+// ParseBuffer parses the lines of buf starting before size. If skipFirst is set
+// buf starts in the middle of a line which belongs to the previous chunk.
+func ParseBuffer(buf []byte, skipFirst bool, size int, opts onebrc.Options) onebrc.Result {
+	p := &chunkParser{stats: make(onebrc.Result, min(maxNameNum, size/8+1)), opts: opts}
+	n := len(buf)
+
+	lastName := make([]byte, maxNameLen) // last name parsed
+	var lastNameLen int
+	isScanningName := true // currently scanning name or value?
+
+	// skip the remainder of the line owned by the previous chunk
+	var idx, start int
+	if skipFirst {
+		for idx < n {
+			if buf[idx] == '\n' {
+				idx++
+				start = idx
+				break
+			}
+			idx++
+		}
+	}
+	// tick tock between parsing names and values while accummulating stats.
+	// terminate when we hit the first newline after the intended size OR
+	// when we hit the end of the file
+	for !(isScanningName && idx >= size) && idx < n {
+		if isScanningName {
+			for idx < n {
+				if buf[idx] == ';' {
+					nameBs := buf[start:idx]
+					lastNameLen = copy(lastName, nameBs)
+
+					idx++
+					start = idx
+					isScanningName = false
+					break
+				}
+				idx++
+			}
+		} else {
+			for idx < n {
+				if buf[idx] == '\n' {
+					p.addValue(lastName[:lastNameLen], buf[start:idx])
+
+					idx++
+					start = idx
+					isScanningName = true
+					break
+				}
+				idx++
+			}
+			// the last line of the file may omit the newline
+			if !isScanningName && idx >= n && start < n {
+				p.addValue(lastName[:lastNameLen], buf[start:n])
+				isScanningName = true
+			}
+		}
+	}
+
+	if f := opts.Filter; f != nil {
+		f.Count(p.stationRows, p.tempRows)
+	}
+	if a := opts.Aliases; a != nil {
+		a.Count(p.variants)
+	}
+	return p.stats
+}
+
+// chunkParser accumulates the values of one chunk.
+type chunkParser struct {
+	stats onebrc.Result
+	opts  onebrc.Options
+
+	// opts.Filter decision per station and the rows dropped
+	accepted              map[string]bool
+	stationRows, tempRows int64
+
+	variants onebrc.Variants // raw names resolved by opts.Aliases
+}
+
+func (p *chunkParser) addValue(name, valueBs []byte) {
+	value := parseTenthsFast(valueBs)
+
+	var v *onebrc.Variant
+	if a := p.opts.Aliases; a != nil {
+		if p.variants == nil {
+			p.variants = make(onebrc.Variants)
+		}
+		v = p.variants.Resolve(a, name)
+		name = v.Bytes()
+	}
+
+	nameUnsafe := unsafe.String(unsafe.SliceData(name), len(name))
+	if s, ok := p.stats[nameUnsafe]; ok {
+		if f := p.opts.Filter; f != nil && !f.AcceptTemp(value) {
+			p.tempRows++
+			return
+		}
+		s.Add(value)
+		if v != nil {
+			v.Rows++
+		}
+		return
+	}
+
+	if f := p.opts.Filter; f != nil {
+		ok, seen := p.accepted[nameUnsafe]
+		if !seen {
+			if p.accepted == nil {
+				p.accepted = make(map[string]bool)
+			}
+			ok = f.AcceptStation(name)
+			p.accepted[string(name)] = ok
+		}
+		if !ok {
+			p.stationRows++
+			return
+		}
+		if !f.AcceptTemp(value) {
+			p.tempRows++
+			return
+		}
+	}
+	s := &onebrc.Stats{Digest: p.opts.NewDigest()}
+	s.Add(value)
+	if v != nil {
+		v.Rows++
+	}
+	p.stats[string(name)] = s // actually allocate string
+}
+
+// parseTenthsFast is a high performance parser using the assumption that
+// the byte slice will always have a single decimal digit. It returns the value
+// in tenths of a degree.
+func parseTenthsFast(bs []byte) int64 {
+	var intStartIdx int // is negative?
+	if bs[0] == '-' {
+		intStartIdx = 1
+	}
+
+	v := int64(bs[len(bs)-1] - '0') // single decimal digit
+	place := int64(10)
+	for i := len(bs) - 3; i >= intStartIdx; i-- { // integer part
+		v += int64(bs[i]-'0') * place
+		place *= 10
+	}
+
+	if intStartIdx == 1 {
+		v *= -1
+	}
+	return v
+}
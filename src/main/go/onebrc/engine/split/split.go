@@ -0,0 +1,309 @@
+// Package split is the engine derived from Paschalis Rompanos' implementation:
+// the file is split into one newline aligned part per worker and every part
+// is read sequentially in large blocks.
+package split
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"onebrc"
+)
+
+// readBlockSize is the size of the blocks read by ReadBlocks. A block always
+// holds many complete lines, the incomplete tail is carried over to the next one.
+const readBlockSize = 4 * 1024 * 1024
+
+type Engine struct {
+	opts onebrc.Options
+}
+
+func New(opts onebrc.Options) onebrc.Aggregator {
+	return &Engine{opts: opts}
+}
+
+func (e *Engine) Aggregate(path string) (onebrc.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := SplitFile(f, st.Size(), e.opts.NumWorkers())
+	if err != nil {
+		return nil, fmt.Errorf("error splitting file: %w", err)
+	}
+
+	type partResult struct {
+		result onebrc.Result
+		err    error
+	}
+	resultsCh := make(chan partResult, len(parts))
+	for _, p := range parts {
+		go func(p Part) {
+			result := make(onebrc.Result)
+			err := ReadBlocks(io.NewSectionReader(f, p.Offset, p.Size), func(block []byte) error {
+				return ParseBlockWith(block, result, e.opts)
+			})
+			resultsCh <- partResult{result, err}
+		}(p)
+	}
+
+	weatherStats := make(onebrc.Result)
+	for range parts {
+		partial := <-resultsCh
+		if partial.err != nil {
+			err = partial.err
+			continue
+		}
+		weatherStats.Merge(partial.result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return weatherStats, nil
+}
+
+// Part is a newline aligned byte range of a file.
+type Part struct {
+	Offset, Size int64
+}
+
+// SplitFile divides the first size bytes of f into at most numParts parts of
+// roughly equal size. Every part but the last ends right after a newline, so
+// no line is ever split between two parts regardless of its length.
+func SplitFile(f io.ReaderAt, size int64, numParts int) ([]Part, error) {
+	n := int64(max(numParts, 1))
+
+	parts := make([]Part, 0, n)
+	offset := int64(0)
+	for i := int64(1); offset < size; i++ {
+		// the part ends after the first newline at or after its nominal
+		// end, i/n of the way through the file, so the last one ends at size
+		nextOffset, err := NextLineStart(f, max(size*i/n-1, offset), size)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{offset, nextOffset - offset})
+		offset = nextOffset
+	}
+	return parts, nil
+}
+
+// NextLineStart returns the offset just past the first newline at or after
+// from, or size if the rest of the file has no newline.
+func NextLineStart(f io.ReaderAt, from, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for from < size {
+		n, err := f.ReadAt(buf[:min(int64(len(buf)), size-from)], from)
+		if newline := bytes.IndexByte(buf[:n], '\n'); newline >= 0 {
+			return from + int64(newline) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		from += int64(n)
+	}
+	return size, nil
+}
+
+// ParseReader reads r in large blocks and accumulates every complete line into
+// stationStats. A trailing line without newline is accepted at EOF.
+func ParseReader(r io.Reader, stationStats onebrc.Result) error {
+	return ReadBlocks(r, func(block []byte) error {
+		return ParseBlock(block, stationStats)
+	})
+}
+
+// ReadBlocks reads r in large blocks and calls parse with every block of
+// complete lines. The last block may end without newline.
+func ReadBlocks(r io.Reader, parse func(block []byte) error) error {
+	r = Strict(r)
+	buf := make([]byte, readBlockSize)
+	filled := 0
+	for {
+		n, err := io.ReadFull(r, buf[filled:])
+		filled += n
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+
+		block := buf[:filled]
+		if !eof {
+			newline := bytes.LastIndexByte(block, '\n')
+			if newline < 0 {
+				return fmt.Errorf("line longer than %d bytes", len(buf))
+			}
+			block = block[:newline+1]
+		}
+		if err := parse(block); err != nil {
+			return err
+		}
+		if eof {
+			return nil
+		}
+
+		filled = copy(buf, buf[len(block):filled])
+	}
+}
+
+// Strict wraps r so that an io.ErrUnexpectedEOF returned by r itself, like a
+// decompressor's for a truncated stream, is not mistaken for the short last
+// block reported by io.ReadFull.
+func Strict(r io.Reader) io.Reader {
+	return strictReader{r}
+}
+
+type strictReader struct {
+	r io.Reader
+}
+
+func (s strictReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("truncated input: %w", err)
+	}
+	return n, err
+}
+
+// ParseBlock accumulates the lines of block into stationStats. Lines without
+// a semicolon are skipped. The station name is only copied into a string when
+// it is seen for the first time.
+func ParseBlock(block []byte, stationStats onebrc.Result) error {
+	return ParseBlockWith(block, stationStats, onebrc.Options{})
+}
+
+// ParseBlockWith is ParseBlock with a digest per new station if
+// opts.Compression is set, the rows selected by opts.Filter and the station
+// names folded by opts.Aliases.
+func ParseBlockWith(block []byte, stationStats onebrc.Result, opts onebrc.Options) error {
+	var c Counts
+	defer c.Flush(opts)
+	return ParseBlockCounting(block, stationStats, opts, &c)
+}
+
+// Counts are the rows rejected by a filter and the variants resolved by
+// aliases while parsing, kept until they are known to belong to the result.
+type Counts struct {
+	StationRows, TempRows int64
+	Variants              onebrc.Variants
+}
+
+// Flush adds c to opts.Filter and opts.Aliases.
+func (c *Counts) Flush(opts onebrc.Options) {
+	if f := opts.Filter; f != nil {
+		f.Count(c.StationRows, c.TempRows)
+	}
+	if a := opts.Aliases; a != nil && c.Variants != nil {
+		a.Count(c.Variants)
+	}
+}
+
+// ParseBlockCounting is ParseBlockWith adding the rows rejected by
+// opts.Filter and the variants resolved by opts.Aliases to c instead of
+// counting them right away.
+func ParseBlockCounting(block []byte, stationStats onebrc.Result, opts onebrc.Options, c *Counts) error {
+	filter := opts.Filter
+	var accepted map[string]bool // filter decision per station
+	if filter != nil {
+		accepted = make(map[string]bool)
+	}
+	aliases := opts.Aliases
+	if aliases != nil && c.Variants == nil {
+		c.Variants = make(onebrc.Variants)
+	}
+
+	for len(block) > 0 {
+		var line []byte
+		if newline := bytes.IndexByte(block, '\n'); newline >= 0 {
+			line, block = block[:newline], block[newline+1:]
+		} else {
+			line, block = block, nil
+		}
+
+		semi := bytes.IndexByte(line, ';')
+		if semi < 0 {
+			continue
+		}
+		station := line[:semi]
+
+		temp, ok := ParseTenths(line[semi+1:])
+		if !ok {
+			return fmt.Errorf("error parsing temperature %q", line[semi+1:])
+		}
+
+		var v *onebrc.Variant
+		if aliases != nil {
+			v = c.Variants.Resolve(aliases, station)
+			station = v.Bytes()
+		}
+
+		// the string conversion in a map index expression does not allocate
+		s := stationStats[string(station)]
+		if filter != nil {
+			ok, seen := accepted[string(station)]
+			if !seen {
+				ok = filter.AcceptStation(station)
+				accepted[string(station)] = ok
+			}
+			if !ok {
+				c.StationRows++
+				continue
+			}
+			if !filter.AcceptTemp(temp) {
+				c.TempRows++
+				continue
+			}
+		}
+		if s == nil {
+			s = &onebrc.Stats{Digest: opts.NewDigest()}
+			stationStats[string(station)] = s
+		}
+		s.Add(temp)
+		if v != nil {
+			v.Rows++
+		}
+	}
+	return nil
+}
+
+// ParseTenths parses a temperature with exactly one fractional digit, e.g.
+// "-12.3", and returns it in tenths of a degree, i.e. -123.
+func ParseTenths(b []byte) (int64, bool) {
+	negative := len(b) > 0 && b[0] == '-'
+	if negative {
+		b = b[1:]
+	}
+	if len(b) < 3 || b[len(b)-2] != '.' {
+		return 0, false
+	}
+
+	var temp int64
+	for _, c := range b[:len(b)-2] {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		temp = temp*10 + int64(c-'0')
+	}
+	c := b[len(b)-1]
+	if c < '0' || c > '9' {
+		return 0, false
+	}
+	temp = temp*10 + int64(c-'0')
+
+	if negative {
+		return -temp, true
+	}
+	return temp, true
+}
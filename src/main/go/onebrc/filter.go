@@ -0,0 +1,73 @@
+package onebrc
+
+import (
+	"math"
+	"regexp"
+	"sync/atomic"
+)
+
+// Filter selects the rows the engines aggregate. It is applied while
+// parsing: a station is checked once per chunk when it is first seen, the
+// engines remember the decision in their per-chunk hash tables, so rows of
+// excluded stations are dropped by a lookup before any stats work. A Filter
+// is safe for concurrent use and counts the rows it rejected.
+type Filter struct {
+	// Include, if not nil, keeps only stations matching it.
+	Include *regexp.Regexp
+	// Exclude, if not nil, drops stations matching it.
+	Exclude *regexp.Regexp
+	// Stations, if not nil, keeps only the listed stations.
+	Stations map[string]bool
+	// ExcludeStations drops the listed stations.
+	ExcludeStations map[string]bool
+	// Min and Max are the inclusive window of temperatures kept, in tenths
+	// of a degree. NewFilter sets them to keep everything.
+	Min, Max int64
+
+	stationRows, tempRows atomic.Int64
+}
+
+// NewFilter returns a filter that keeps every row.
+func NewFilter() *Filter {
+	return &Filter{Min: math.MinInt64, Max: math.MaxInt64}
+}
+
+// AcceptStation reports whether rows of the station are kept.
+func (f *Filter) AcceptStation(name []byte) bool {
+	if f.Stations != nil && !f.Stations[string(name)] {
+		return false
+	}
+	if f.ExcludeStations[string(name)] {
+		return false
+	}
+	if f.Include != nil && !f.Include.Match(name) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.Match(name) {
+		return false
+	}
+	return true
+}
+
+// AcceptTemp reports whether a temperature in tenths of a degree is inside
+// the window.
+func (f *Filter) AcceptTemp(temp int64) bool {
+	return temp >= f.Min && temp <= f.Max
+}
+
+// Count adds the rows a parser rejected because of their station or their
+// temperature. Parsers count per chunk and call it once per chunk.
+func (f *Filter) Count(stationRows, tempRows int64) {
+	if stationRows > 0 {
+		f.stationRows.Add(stationRows)
+	}
+	if tempRows > 0 {
+		f.tempRows.Add(tempRows)
+	}
+}
+
+// Rejected returns the number of rows dropped so far because of their
+// station and, of the remaining ones, because of their temperature.
+func (f *Filter) Rejected() (stationRows, tempRows int64) {
+	return f.stationRows.Load(), f.tempRows.Load()
+}
@@ -0,0 +1,42 @@
+package onebrc
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := NewFilter()
+	if !f.AcceptStation([]byte("anything")) || !f.AcceptTemp(-999) || !f.AcceptTemp(999) {
+		t.Fatal("NewFilter should keep everything")
+	}
+
+	f.Include = regexp.MustCompile("^Ham")
+	f.Exclude = regexp.MustCompile("burg$")
+	f.Stations = map[string]bool{"Hamburg": true, "Hamm": true, "Hameln": true}
+	f.ExcludeStations = map[string]bool{"Hameln": true}
+	for name, expected := range map[string]bool{
+		"Hamburg": false, // excluded by regexp
+		"Hamm":    true,
+		"Hameln":  false, // excluded by list
+		"Hamina":  false, // not listed
+		"Abha":    false,
+	} {
+		if actual := f.AcceptStation([]byte(name)); actual != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, actual)
+		}
+	}
+
+	f.Min, f.Max = -50, 50
+	for temp, expected := range map[int64]bool{-51: false, -50: true, 0: true, 50: true, 51: false} {
+		if actual := f.AcceptTemp(temp); actual != expected {
+			t.Errorf("%d: expected %v, got %v", temp, expected, actual)
+		}
+	}
+
+	f.Count(3, 0)
+	f.Count(1, 2)
+	if s, r := f.Rejected(); s != 4 || r != 2 {
+		t.Errorf("Expected 4 and 2 rejected rows, got %d and %d", s, r)
+	}
+}
@@ -0,0 +1,214 @@
+// Package follow tails a growing measurements file, like tail -F, and keeps
+// per-station aggregates of everything written to it. Appended complete lines
+// are added with the split engine's block parser, a partial trailing line is
+// kept until its newline arrives. Malformed lines are skipped and counted as
+// rejected. If the file is replaced, e.g. by log
+// rotation, the rest of the old file is read before switching to the new one.
+// If it is truncated it is read again from the start.
+package follow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"onebrc"
+	"onebrc/engine/mmap"
+	"onebrc/engine/split"
+	"onebrc/metrics"
+)
+
+// Options tune Follow. Zero values select the defaults.
+type Options struct {
+	// Interval between two emitted results, 1s by default. Nothing is
+	// emitted for an interval without new lines.
+	Interval time.Duration
+	// Poll is the interval at which the file is checked when no change
+	// notification arrives, 250ms by default. Without inotify this is the
+	// only way changes are noticed.
+	Poll time.Duration
+	// NoInotify forces polling.
+	NoInotify bool
+	// Metrics, if not nil, records bytes read, lines, rejected lines and
+	// chunk latencies.
+	Metrics *metrics.Processing
+	// Parse selects the digests, filter and aliases applied to the lines.
+	// Its workers and chunk size are not used.
+	Parse onebrc.Options
+}
+
+// readBlockSize is the number of bytes read at a time.
+const readBlockSize = 4 * 1024 * 1024
+
+// Follow tails the file at path until ctx is done and calls emit with the
+// aggregates so far. emit must not keep the result after it returns. The file
+// does not need to exist yet.
+func Follow(ctx context.Context, path string, opts Options, emit func(onebrc.Result)) error {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Poll <= 0 {
+		opts.Poll = 250 * time.Millisecond
+	}
+
+	var events <-chan struct{}
+	if !opts.NoInotify {
+		w, err := newWatcher(filepath.Dir(path))
+		if err == nil {
+			defer w.Close()
+			events = w.events
+		}
+	}
+
+	t := &tailer{path: path, result: make(onebrc.Result), buf: make([]byte, readBlockSize), metrics: opts.Metrics, opts: opts.Parse}
+	defer t.close()
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	poll := time.NewTicker(opts.Poll)
+	defer poll.Stop()
+
+	for {
+		if err := t.update(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			if t.changed {
+				emit(t.result)
+			}
+			return nil
+		case <-ticker.C:
+			if t.changed {
+				emit(t.result)
+				t.changed = false
+			}
+		case <-events:
+		case <-poll.C:
+		}
+	}
+}
+
+type tailer struct {
+	path    string
+	f       *os.File
+	offset  int64
+	partial []byte // incomplete last line
+	buf     []byte
+	result  onebrc.Result
+	changed bool
+	metrics *metrics.Processing
+	opts    onebrc.Options
+}
+
+// update reads whatever was appended since the last call.
+func (t *tailer) update() error {
+	if t.f == nil {
+		f, err := os.Open(t.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // wait for it to be created
+		}
+		if err != nil {
+			return err
+		}
+		t.f, t.offset, t.partial = f, 0, t.partial[:0]
+	}
+
+	st, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() < t.offset {
+		// truncated in place
+		t.offset, t.partial = 0, t.partial[:0]
+	}
+	if err := t.readToEnd(); err != nil {
+		return err
+	}
+
+	// replaced: the old file is fully read, continue with the new one
+	if current, err := os.Stat(t.path); err == nil && !os.SameFile(st, current) {
+		t.close()
+		return t.update()
+	}
+	return nil
+}
+
+func (t *tailer) readToEnd() error {
+	for {
+		n, err := t.f.ReadAt(t.buf, t.offset)
+		if n > 0 {
+			t.metrics.Read(n)
+			t.offset += int64(n)
+			t.consume(t.buf[:n])
+		}
+		if err == io.EOF || n == 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tailer) consume(data []byte) {
+	newline := bytes.LastIndexByte(data, '\n')
+	if newline < 0 {
+		t.partial = append(t.partial, data...)
+		return
+	}
+
+	if len(t.partial) > 0 {
+		// complete the partial line first
+		first := bytes.IndexByte(data, '\n')
+		t.partial = append(t.partial, data[:first+1]...)
+		t.process(t.partial)
+		data = data[first+1:]
+		newline -= first + 1
+	}
+	if newline >= 0 {
+		t.process(data[:newline+1])
+	}
+	t.partial = append(t.partial[:0], data[newline+1:]...)
+	t.changed = true
+}
+
+// process aggregates complete lines. Invalid lines are left out, the valid
+// ones between them are added to the result as one block.
+func (t *tailer) process(lines []byte) {
+	start := time.Now()
+	var n, rejected int64
+	valid := 0 // start of the valid lines not parsed yet
+	for offset := 0; offset < len(lines); {
+		end := offset + bytes.IndexByte(lines[offset:], '\n')
+		if mmap.CheckLine(lines[offset:end]) != nil {
+			t.merge(lines[valid:offset])
+			valid = end + 1
+			rejected++
+		} else {
+			n++
+		}
+		offset = end + 1
+	}
+	t.merge(lines[valid:])
+	if t.metrics != nil {
+		t.metrics.Chunk(n, time.Since(start))
+		t.metrics.Reject(rejected)
+	}
+}
+
+func (t *tailer) merge(lines []byte) {
+	// the lines were checked, so parsing cannot fail
+	split.ParseBlockWith(lines, t.result, t.opts)
+}
+
+func (t *tailer) close() {
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+}
@@ -0,0 +1,188 @@
+package follow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"onebrc"
+	"onebrc/metrics"
+)
+
+func appendTo(t *testing.T, path, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+// start runs Follow in the background and returns a channel receiving a copy
+// of every emitted result.
+func start(t *testing.T, path string, opts Options) <-chan onebrc.Result {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan onebrc.Result, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- Follow(ctx, path, opts, func(r onebrc.Result) {
+			results <- r.Clone()
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return results
+}
+
+// await waits for a result holding the expected stats.
+func await(t *testing.T, results <-chan onebrc.Result, expected map[string]onebrc.Stats) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	var last onebrc.Result
+	for {
+		select {
+		case r := <-results:
+			if equal(r, expected) {
+				return
+			}
+			last = r
+		case <-timeout:
+			t.Fatalf("Expected %v, last emitted %v", expected, last)
+		}
+	}
+}
+
+func equal(r onebrc.Result, expected map[string]onebrc.Stats) bool {
+	if len(r) != len(expected) {
+		return false
+	}
+	for name, e := range expected {
+		if a := r[name]; a == nil || *a != e {
+			return false
+		}
+	}
+	return true
+}
+
+func testFollow(t *testing.T, opts Options) {
+	opts.Interval = 10 * time.Millisecond
+	opts.Poll = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	results := start(t, path, opts)
+
+	// created after following started
+	appendTo(t, path, "a;1.0\nb;-2.5\n")
+	await(t, results, map[string]onebrc.Stats{
+		"a": {Min: 10, Max: 10, Sum: 10, Count: 1, SumSquares: 100},
+		"b": {Min: -25, Max: -25, Sum: -25, Count: 1, SumSquares: 625},
+	})
+
+	// a partial line only counts once complete
+	appendTo(t, path, "a;3.0\nb;-")
+	await(t, results, map[string]onebrc.Stats{
+		"a": {Min: 10, Max: 30, Sum: 40, Count: 2, SumSquares: 1000},
+		"b": {Min: -25, Max: -25, Sum: -25, Count: 1, SumSquares: 625},
+	})
+	appendTo(t, path, "1.5\n")
+	await(t, results, map[string]onebrc.Stats{
+		"a": {Min: 10, Max: 30, Sum: 40, Count: 2, SumSquares: 1000},
+		"b": {Min: -25, Max: -15, Sum: -40, Count: 2, SumSquares: 850},
+	})
+
+	// rotated: lines written to the old file before the switch still count
+	rotated := path + ".1"
+	appendTo(t, path, "c;0.5\n")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendTo(t, rotated, "c;0.7\n")
+	appendTo(t, path, "c;9.9\n")
+	await(t, results, map[string]onebrc.Stats{
+		"a": {Min: 10, Max: 30, Sum: 40, Count: 2, SumSquares: 1000},
+		"b": {Min: -25, Max: -15, Sum: -40, Count: 2, SumSquares: 850},
+		"c": {Min: 5, Max: 99, Sum: 111, Count: 3, SumSquares: 9875},
+	})
+
+	// truncated in place
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendTo(t, path, "d;1.2\n")
+	await(t, results, map[string]onebrc.Stats{
+		"a": {Min: 10, Max: 30, Sum: 40, Count: 2, SumSquares: 1000},
+		"b": {Min: -25, Max: -15, Sum: -40, Count: 2, SumSquares: 850},
+		"c": {Min: 5, Max: 99, Sum: 111, Count: 3, SumSquares: 9875},
+		"d": {Min: 12, Max: 12, Sum: 12, Count: 1, SumSquares: 144},
+	})
+}
+
+func TestFollowInotify(t *testing.T) {
+	testFollow(t, Options{})
+}
+
+func TestFollowPolling(t *testing.T) {
+	testFollow(t, Options{NoInotify: true})
+}
+
+func TestConsumeSplitsLinesAcrossReads(t *testing.T) {
+	tl := &tailer{result: make(onebrc.Result)}
+	for _, part := range []string{"al", "pha;1", ".0\nbeta;2.0\ngam", "ma;3.0", "\n"} {
+		tl.consume([]byte(part))
+	}
+	expected := map[string]onebrc.Stats{
+		"alpha": {Min: 10, Max: 10, Sum: 10, Count: 1, SumSquares: 100},
+		"beta":  {Min: 20, Max: 20, Sum: 20, Count: 1, SumSquares: 400},
+		"gamma": {Min: 30, Max: 30, Sum: 30, Count: 1, SumSquares: 900},
+	}
+	if !equal(tl.result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tl.result)
+	}
+	if len(tl.partial) != 0 {
+		t.Fatalf("Expected no partial line, got %q", tl.partial)
+	}
+}
+
+func TestProcessRejectsInvalidLines(t *testing.T) {
+	tl := &tailer{result: make(onebrc.Result), metrics: metrics.NewProcessing()}
+	tl.consume([]byte("a\nb;1.0\nc;00012.3\n;1.0\nd;-2.5\n"))
+	expected := map[string]onebrc.Stats{
+		"b": {Min: 10, Max: 10, Sum: 10, Count: 1, SumSquares: 100},
+		"d": {Min: -25, Max: -25, Sum: -25, Count: 1, SumSquares: 625},
+	}
+	if !equal(tl.result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tl.result)
+	}
+	if lines, rejected := tl.metrics.LinesParsed.Value(), tl.metrics.LinesRejected.Value(); lines != 2 || rejected != 3 {
+		t.Fatalf("Expected 2 lines and 3 rejected, got %d and %d", lines, rejected)
+	}
+}
+
+func TestProcessAppliesOptions(t *testing.T) {
+	filter := onebrc.NewFilter()
+	filter.Exclude = regexp.MustCompile("^x")
+	aliases := &onebrc.Aliases{TrimSpace: true}
+	tl := &tailer{result: make(onebrc.Result), opts: onebrc.Options{Filter: filter, Aliases: aliases}}
+	tl.consume([]byte(" a ;1.0\nx;2.0\na;3.0\n"))
+	expected := map[string]onebrc.Stats{
+		"a": {Min: 10, Max: 30, Sum: 40, Count: 2, SumSquares: 1000},
+	}
+	if !equal(tl.result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tl.result)
+	}
+}
@@ -0,0 +1,46 @@
+package follow
+
+import (
+	"os"
+	"syscall"
+)
+
+// watcher signals changes in a directory via inotify. Watching the directory
+// and not the file also catches the file being created or replaced.
+type watcher struct {
+	f      *os.File
+	events chan struct{}
+}
+
+func newWatcher(dir string) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	const mask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// a non-blocking fd is handled by the runtime poller, so Close
+	// interrupts the pending Read
+	w := &watcher{f: os.NewFile(uintptr(fd), "inotify"), events: make(chan struct{}, 1)}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			if _, err := w.f.Read(buf); err != nil {
+				return
+			}
+			select {
+			case w.events <- struct{}{}:
+			default: // a wake-up is already pending
+			}
+		}
+	}()
+	return w, nil
+}
+
+func (w *watcher) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package follow
+
+import "errors"
+
+type watcher struct {
+	events chan struct{}
+}
+
+func newWatcher(dir string) (*watcher, error) {
+	return nil, errors.New("inotify is only available on linux")
+}
+
+func (w *watcher) Close() error {
+	return nil
+}
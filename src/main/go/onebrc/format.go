@@ -0,0 +1,115 @@
+package onebrc
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Formatter writes a Result.
+type Formatter interface {
+	Format(w io.Writer, r Result) error
+}
+
+// BraceFormatter writes the reference output format, e.g.
+// {Abha=-23.0/18.0/59.2, Abidjan=-16.2/26.0/67.3}.
+type BraceFormatter struct {
+	// Order returns the stations to write in order, Result.Names if nil.
+	Order func(Result) []string
+}
+
+func (f BraceFormatter) Format(w io.Writer, r Result) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	for i, name := range names(r, f.Order) {
+		if i > 0 {
+			bw.WriteString(", ")
+		}
+		s := r[name]
+		bw.WriteString(name)
+		bw.WriteByte('=')
+		bw.WriteString(FormatTenths(s.Min))
+		bw.WriteByte('/')
+		bw.WriteString(FormatTemp(s.Mean()))
+		bw.WriteByte('/')
+		bw.WriteString(FormatTenths(s.Max))
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// QuantileFormatter writes a tab separated table of min, mean, max and the
+// given quantiles of every station, e.g. for Quantiles 0.5 and 0.95
+//
+//	station	min	mean	max	p50	p95
+//	Abha	-23.0	18.0	59.2	18.1	34.5
+//
+// Quantiles of stations without a digest are written as NaN.
+type QuantileFormatter struct {
+	Quantiles []float64
+	// Order returns the stations to write in order, Result.Names if nil.
+	Order func(Result) []string
+}
+
+func (f QuantileFormatter) Format(w io.Writer, r Result) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("station\tmin\tmean\tmax")
+	for _, q := range f.Quantiles {
+		bw.WriteString("\tp")
+		bw.WriteString(strconv.FormatFloat(q*100, 'f', -1, 64))
+	}
+	bw.WriteByte('\n')
+
+	for _, name := range names(r, f.Order) {
+		s := r[name]
+		bw.WriteString(name)
+		for _, v := range []string{FormatTenths(s.Min), FormatTemp(s.Mean()), FormatTenths(s.Max)} {
+			bw.WriteByte('\t')
+			bw.WriteString(v)
+		}
+		for _, q := range f.Quantiles {
+			bw.WriteByte('\t')
+			bw.WriteString(FormatTemp(s.Quantile(q)))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func names(r Result, order func(Result) []string) []string {
+	if order == nil {
+		return r.Names()
+	}
+	return order(r)
+}
+
+// FormatTenths formats a temperature given in tenths of a degree.
+func FormatTenths(temp int64) string {
+	return FormatTemp(float64(temp) / 10.0)
+}
+
+// FormatTemp rounds x to one decimal place the way the reference
+// implementation does and formats it.
+func FormatTemp(x float64) string {
+	return strconv.FormatFloat(Round(x), 'f', 1, 64)
+}
+
+// Round rounds x to one decimal place, see java's Math.round(x * 10.0) / 10.0.
+func Round(x float64) float64 {
+	return RoundJava(x*10.0) / 10.0
+}
+
+// RoundJava returns the closest integer to the argument, with ties
+// rounding to positive infinity, see java's Math.round
+func RoundJava(x float64) float64 {
+	t := math.Trunc(x)
+	if x-t >= 0.5 || t-x > 0.5 {
+		t += math.Copysign(1, x)
+	}
+
+	if t == 0 { // check -0
+		return 0.0
+	}
+	return t
+}
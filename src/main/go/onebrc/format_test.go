@@ -0,0 +1,170 @@
+package onebrc
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestRoundJava(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		expected string
+	}{
+		{value: -1.5, expected: "-1.0"},
+		{value: -1.0, expected: "-1.0"},
+		{value: -0.7, expected: "-1.0"},
+		{value: -0.5, expected: "0.0"},
+		{value: -0.3, expected: "0.0"},
+		{value: 0.0, expected: "0.0"},
+		{value: 0.3, expected: "0.0"},
+		{value: 0.5, expected: "1.0"},
+		{value: 0.7, expected: "1.0"},
+		{value: 1.0, expected: "1.0"},
+		{value: 1.5, expected: "2.0"},
+	} {
+		if rounded := RoundJava(tc.value); fmt.Sprintf("%.1f", rounded) != tc.expected {
+			t.Errorf("Wrong rounding of %v, expected: %s, got: %.1f", tc.value, tc.expected, rounded)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		expected string
+	}{
+		{value: -1.25, expected: "-1.2"},
+		{value: -1.26, expected: "-1.3"},
+		{value: -0.04, expected: "0.0"},
+		{value: 0.0, expected: "0.0"},
+		{value: 1.25, expected: "1.3"},
+		{value: 12.34, expected: "12.3"},
+	} {
+		if rounded := Round(tc.value); fmt.Sprintf("%.1f", rounded) != tc.expected {
+			t.Errorf("Wrong rounding of %v, expected: %s, got: %.1f", tc.value, tc.expected, rounded)
+		}
+	}
+}
+
+func TestBraceFormatter(t *testing.T) {
+	r := Result{}
+	for _, m := range []struct {
+		name string
+		temp int64
+	}{
+		{"New York", 105},
+		{"New York", 156},
+		{"Los Angeles", 187},
+		{"Los Angeles", 203},
+		{"Zero", -1},
+		{"Zero", 0},
+	} {
+		if r[m.name] == nil {
+			r[m.name] = &Stats{}
+		}
+		r[m.name].Add(m.temp)
+	}
+
+	var buf bytes.Buffer
+	if err := (BraceFormatter{}).Format(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "{Los Angeles=18.7/19.5/20.3, New York=10.5/13.1/15.6, Zero=-0.1/0.0/0.0}\n"
+	if buf.String() != expected {
+		t.Errorf("Wrong output, expected: %q, got: %q", expected, buf.String())
+	}
+}
+
+func TestResultMerge(t *testing.T) {
+	a := Result{"a": {Min: -10, Max: 10, Sum: 0, Count: 2}}
+	b := Result{"a": {Min: -20, Max: 5, Sum: -15, Count: 2}, "b": {Min: 1, Max: 1, Sum: 1, Count: 1}}
+
+	a.Merge(b)
+
+	if s := *a["a"]; s != (Stats{Min: -20, Max: 10, Sum: -15, Count: 4}) {
+		t.Errorf("Wrong merge of a: %+v", s)
+	}
+	if s := *a["b"]; s != (Stats{Min: 1, Max: 1, Sum: 1, Count: 1}) {
+		t.Errorf("Wrong merge of b: %+v", s)
+	}
+	if rows := a.Rows(); rows != 5 {
+		t.Errorf("Wrong rows, expected: 5, got: %d", rows)
+	}
+}
+
+func TestQuantileFormatter(t *testing.T) {
+	opts := Options{Compression: 100}
+	r := Result{"b": {Digest: opts.NewDigest()}, "a": {}}
+	for temp := int64(1); temp <= 100; temp++ {
+		r["b"].Add(temp)
+	}
+	r["a"].Add(-5)
+
+	var buf bytes.Buffer
+	if err := (QuantileFormatter{Quantiles: []float64{0, 0.5, 0.99}}).Format(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "station\tmin\tmean\tmax\tp0\tp50\tp99\n" +
+		"a\t-0.5\t-0.5\t-0.5\tNaN\tNaN\tNaN\n" +
+		"b\t0.1\t5.1\t10.0\t0.1\t5.1\t10.0\n"
+	if buf.String() != expected {
+		t.Errorf("Wrong output, expected: %q, got: %q", expected, buf.String())
+	}
+}
+
+func TestStatsMergeDigests(t *testing.T) {
+	opts := Options{Compression: 100}
+	a := &Stats{Digest: opts.NewDigest()}
+	b := &Stats{Digest: opts.NewDigest()}
+	for temp := int64(0); temp < 50; temp++ {
+		a.Add(temp)
+		b.Add(temp + 50)
+	}
+
+	var merged Stats
+	merged.Merge(a)
+	merged.Merge(b)
+	if merged.Digest == a.Digest {
+		t.Error("Merge shares the digest of its argument")
+	}
+	if c := merged.Digest.Count(); c != 100 {
+		t.Errorf("Wrong digest count, expected: 100, got: %v", c)
+	}
+	if a.Digest.Count() != 50 {
+		t.Errorf("Merge modified its argument, count: %v", a.Digest.Count())
+	}
+	if q := merged.Quantile(0.5); q < 4.8 || q > 5.2 {
+		t.Errorf("Wrong median, expected about 5.0, got: %v", q)
+	}
+}
+
+func TestStatsStdDev(t *testing.T) {
+	var a, b Stats
+	if !math.IsNaN(a.StdDev()) {
+		t.Errorf("Expected NaN without rows, got: %v", a.StdDev())
+	}
+	// a large offset makes the float version cancel badly
+	for _, temp := range []int64{995, 997, 999} {
+		a.Add(temp)
+		b.Add(temp - 2)
+	}
+	a.Merge(&b)
+	// 993, 995, 995, 997, 997, 999 tenths: sample variance 0.044 degrees²
+	if d := a.StdDev(); math.Abs(d-math.Sqrt(0.044)) > 1e-12 {
+		t.Errorf("Wrong deviation, expected: %v, got: %v", math.Sqrt(0.044), d)
+	}
+
+	unknown := Stats{Min: 1, Max: 1, Sum: 1, Count: 1, SumSquares: -1}
+	a.Merge(&unknown)
+	if a.SumSquares != -1 || !math.IsNaN(a.StdDev()) {
+		t.Errorf("Expected an unknown deviation after merging an unknown one, got: %v", a.StdDev())
+	}
+	a.Add(10)
+	if a.SumSquares != -1 || !math.IsNaN(a.StdDev()) {
+		t.Errorf("Expected an unknown deviation after adding to an unknown one, got: %v", a.StdDev())
+	}
+}
@@ -0,0 +1,171 @@
+// Package gen generates measurements files. Rows are produced in fixed size
+// blocks, each with its own random source derived from the seed and the
+// block index, so the output only depends on the seed and never on the
+// number of workers.
+package gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+
+	"onebrc"
+)
+
+// MaxStations is the maximum number of distinct stations allowed by the spec.
+const MaxStations = onebrc.MaxStations
+
+// blockRows is the number of rows generated from one random source.
+const blockRows = 1 << 16
+
+// Station is a weather station with its long-term mean temperature.
+type Station struct {
+	Name string
+	Mean float64
+}
+
+// LoadStations reads name;mean rows, skipping lines starting with '#'.
+// Only the first occurrence of a name is kept.
+func LoadStations(path string) ([]Station, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var stations []Station
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, meanStr, ok := strings.Cut(line, ";")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: missing ';'", path, lineNum)
+		}
+		mean, err := strconv.ParseFloat(meanStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		if !seen[name] {
+			seen[name] = true
+			stations = append(stations, Station{Name: name, Mean: mean})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+// Generator writes rows of uniformly chosen stations with Gaussian
+// temperatures around each station's mean.
+type Generator struct {
+	Stations []Station
+	StdDev   float64
+	Seed     uint64
+	Workers  int
+
+	// Cover makes the first len(Stations) rows list every station once, so
+	// all of them appear in the output.
+	Cover bool
+	// Sweep replaces the Gaussian temperatures by a cycle through every
+	// value from -99.9 to 99.9.
+	Sweep bool
+	// Skew picks stations from a Zipf distribution with this exponent, which
+	// must be greater than 1, instead of uniformly. Earlier stations are
+	// picked more often.
+	Skew float64
+}
+
+// Write writes rows measurements to w.
+func (g *Generator) Write(w io.Writer, rows int64) error {
+	if len(g.Stations) == 0 {
+		return fmt.Errorf("no stations")
+	}
+
+	blocks := (rows + blockRows - 1) / blockRows
+	workers := int64(max(g.Workers, 1))
+
+	// every worker owns the blocks i with i % workers == worker and hands
+	// them over in order through its own channel
+	chans := make([]chan []byte, workers)
+	done := make(chan struct{})
+	defer close(done)
+	for worker := range workers {
+		ch := make(chan []byte, 2)
+		chans[worker] = ch
+		go func() {
+			defer close(ch)
+			for i := worker; i < blocks; i += workers {
+				n := min(blockRows, rows-i*blockRows)
+				select {
+				case ch <- g.block(uint64(i), int(n)):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for i := range blocks {
+		if _, err := w.Write(<-chans[i%workers]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) block(index uint64, rows int) []byte {
+	rng := rand.New(rand.NewPCG(g.Seed, index))
+	var zipf *rand.Zipf
+	if g.Skew > 1 {
+		zipf = rand.NewZipf(rng, g.Skew, 1, uint64(len(g.Stations)-1))
+	}
+
+	buf := make([]byte, 0, rows*16)
+	for j := range rows {
+		row := index*blockRows + uint64(j)
+
+		var s *Station
+		switch {
+		case g.Cover && row < uint64(len(g.Stations)):
+			s = &g.Stations[row]
+		case zipf != nil:
+			s = &g.Stations[zipf.Uint64()]
+		default:
+			s = &g.Stations[rng.IntN(len(g.Stations))]
+		}
+
+		var temp int64
+		if g.Sweep {
+			temp = int64(row%1999) - 999
+		} else {
+			temp = int64(math.Round((s.Mean + rng.NormFloat64()*g.StdDev) * 10))
+		}
+		buf = appendRow(buf, s.Name, temp)
+	}
+	return buf
+}
+
+// appendRow appends "name;temp\n" with temp in tenths of a degree clamped to
+// the spec range -99.9..99.9.
+func appendRow(buf []byte, name string, temp int64) []byte {
+	temp = min(max(temp, -999), 999)
+	buf = append(buf, name...)
+	buf = append(buf, ';')
+	if temp < 0 {
+		buf = append(buf, '-')
+		temp = -temp
+	}
+	buf = strconv.AppendInt(buf, temp/10, 10)
+	buf = append(buf, '.', byte('0'+temp%10), '\n')
+	return buf
+}
@@ -0,0 +1,98 @@
+package gen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"onebrc"
+	"onebrc/engine/split"
+)
+
+const stationsFile = "../../../../../data/weather_stations.csv"
+
+func TestLoadStations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stations.csv")
+	data := "# comment\n#another;1.0\nA;1.5\n\nB;-2.25\nA;99\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stations, err := LoadStations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Station{{"A", 1.5}, {"B", -2.25}}
+	if len(stations) != len(expected) || stations[0] != expected[0] || stations[1] != expected[1] {
+		t.Errorf("Wrong stations, expected: %v, got: %v", expected, stations)
+	}
+
+	if err := os.WriteFile(path, []byte("A;x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStations(path); err == nil {
+		t.Error("Expected an error for an invalid mean")
+	}
+}
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	stations, err := LoadStations(stationsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const rows = 3*blockRows + 17
+	generate := func(seed uint64, workers int) []byte {
+		var buf bytes.Buffer
+		g := &Generator{Stations: stations[:MaxStations], StdDev: 10, Seed: seed, Workers: workers}
+		if err := g.Write(&buf, rows); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first := generate(42, 1)
+	if !bytes.Equal(first, generate(42, 1)) || !bytes.Equal(first, generate(42, 3)) || !bytes.Equal(first, generate(42, 8)) {
+		t.Error("Same seed produced different output")
+	}
+	if bytes.Equal(first, generate(43, 1)) {
+		t.Error("Different seeds produced the same output")
+	}
+
+	result := make(onebrc.Result)
+	if err := split.ParseBlock(first, result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Rows() != rows {
+		t.Errorf("Wrong rows, expected: %d, got: %d", rows, result.Rows())
+	}
+	if len(result) > MaxStations {
+		t.Errorf("Too many stations: %d", len(result))
+	}
+	for name, s := range result {
+		if s.Min < -999 || s.Max > 999 {
+			t.Errorf("%s out of range: %+v", name, s)
+		}
+	}
+}
+
+func TestGeneratorFollowsMeans(t *testing.T) {
+	stations := []Station{{"Cold", -20}, {"Hot", 30.5}}
+	var buf bytes.Buffer
+	g := &Generator{Stations: stations, StdDev: 10, Seed: 1, Workers: 2}
+	if err := g.Write(&buf, 200_000); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(onebrc.Result)
+	if err := split.ParseBlock(buf.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stations {
+		// the standard error of the mean is about 10/sqrt(100_000) = 0.03
+		if mean := result[s.Name].Mean(); mean < s.Mean-0.2 || mean > s.Mean+0.2 {
+			t.Errorf("%s: mean %.2f too far from %.2f", s.Name, mean, s.Mean)
+		}
+	}
+}
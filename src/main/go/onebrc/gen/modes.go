@@ -0,0 +1,184 @@
+package gen
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"unicode/utf8"
+)
+
+// Modes are the dataset shapes selectable with Configure:
+//
+//   - normal: uniformly chosen stations, Gaussian temperatures
+//   - long-utf8: names of exactly 100 bytes of multibyte UTF-8
+//   - unique-10k: exactly 10,000 distinct stations, all present
+//   - fnv-collide: names whose 64-bit FNV-1a hashes are equal modulo 2^14,
+//     the table size of the mmap engine and AlexanderYastrebov's calc.go
+//   - boundaries: every value from -99.9 to 99.9
+//   - skewed: Zipf distributed station frequencies
+//   - utf16-order: pairs of names whose UTF-8 byte order and UTF-16 code
+//     unit order differ, see onebrc.Collation
+var Modes = []string{"normal", "long-utf8", "unique-10k", "fnv-collide", "boundaries", "skewed", "utf16-order"}
+
+// DefaultSkew is the Zipf exponent of the skewed mode if Skew is unset.
+const DefaultSkew = 1.2
+
+// Configure sets up g for mode. g.Stations and g.Seed must be set, the modes
+// which invent station names draw their means from g.Stations.
+func (g *Generator) Configure(mode string) error {
+	if len(g.Stations) == 0 {
+		return fmt.Errorf("no stations")
+	}
+	rng := rand.New(rand.NewPCG(g.Seed, ^uint64(0)))
+
+	switch mode {
+	case "normal":
+	case "long-utf8":
+		g.Stations = withMeans(longUTF8Names(rng, len(g.Stations)), g.Stations)
+	case "unique-10k":
+		if len(g.Stations) < MaxStations {
+			return fmt.Errorf("%s needs %d stations, got %d", mode, MaxStations, len(g.Stations))
+		}
+		g.Stations = g.Stations[:MaxStations]
+		g.Cover = true
+	case "fnv-collide":
+		g.Stations = withMeans(fnvCollidingNames(rng, len(g.Stations), 1<<14), g.Stations)
+		g.Cover = true
+	case "boundaries":
+		g.Sweep = true
+	case "skewed":
+		if g.Skew <= 1 {
+			g.Skew = DefaultSkew
+		}
+	case "utf16-order":
+		// two names per station, stay within MaxStations
+		g.Stations = withMeans(utf16OrderNames(g.Stations[:(len(g.Stations)+1)/2]), g.Stations)
+		g.Cover = true
+	default:
+		return fmt.Errorf("unknown mode %q, available: %v", mode, Modes)
+	}
+	return nil
+}
+
+func withMeans(names []string, stations []Station) []Station {
+	result := make([]Station, len(names))
+	for i, name := range names {
+		result[i] = Station{Name: name, Mean: stations[i%len(stations)].Mean}
+	}
+	return result
+}
+
+// longUTF8Runes are two, three and four byte runes, none of them special to
+// the parsers.
+var longUTF8Runes = []rune("éßøÆЖЯжλΩ東京大阪한국語ไทย🙂🌍🎉𝄞")
+
+// longUTF8Names returns n distinct names of exactly 100 bytes.
+func longUTF8Names(rng *rand.Rand, n int) []string {
+	const nameLen = 100
+
+	names := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for len(names) < n {
+		var b []byte
+		for len(b) < nameLen {
+			r := longUTF8Runes[rng.IntN(len(longUTF8Runes))]
+			if len(b)+utf8.RuneLen(r) > nameLen {
+				// pad the remaining one to three bytes with two byte runes or ASCII
+				for nameLen-len(b) >= 2 {
+					b = utf8.AppendRune(b, 'é')
+				}
+				if len(b) < nameLen {
+					b = append(b, 'x')
+				}
+				break
+			}
+			b = utf8.AppendRune(b, r)
+		}
+		if name := string(b); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Runes from U+10000 on and runes in U+E000..U+FFFF. The former sort after
+// the latter by UTF-8 bytes and before them by UTF-16 code units.
+var (
+	supplementaryRunes = []rune("🙂🌍🎉𝄞𠀋😀")
+	highBMPRunes       = []rune("ｱﾝ￦＄\uf900ﬀ") // U+F900 is a CJK compatibility ideograph
+)
+
+// utf16OrderNames returns two names per station, the station's name followed
+// by a supplementary and by a high BMP rune.
+func utf16OrderNames(stations []Station) []string {
+	names := make([]string, 0, 2*len(stations))
+	seen := make(map[string]bool, 2*len(stations))
+	for i, s := range stations {
+		for _, r := range []rune{supplementaryRunes[i%len(supplementaryRunes)], highBMPRunes[i%len(highBMPRunes)]} {
+			name := s.Name + " " + string(r)
+			if len(name) <= 100 && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+const (
+	fnv1aOffset64 = 14695981039346656037
+	fnv1aPrime64  = 1099511628211
+)
+
+func fnv1a(s string) uint64 {
+	h := uint64(fnv1aOffset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnv1aPrime64
+	}
+	return h
+}
+
+// fnvCollidingNames returns n distinct ASCII names whose FNV-1a hashes are
+// all equal modulo tableSize, a power of two up to 2^16.
+//
+// The last FNV-1a step is h = (h' ^ b) * prime. As prime is odd it is
+// invertible modulo tableSize, so the name hits the target bucket exactly when
+// (h' ^ b) equals target * prime^-1 in the low bits. The last byte b can only
+// fix the low 8 bits, so random prefixes are tried until the higher bits
+// match and b is a printable character.
+func fnvCollidingNames(rng *rand.Rand, n int, tableSize uint64) []string {
+	mask := tableSize - 1
+
+	// Newton's iteration for the inverse modulo 2^64
+	inverse := uint64(fnv1aPrime64)
+	for range 5 {
+		inverse *= 2 - fnv1aPrime64*inverse
+	}
+	want := (rng.Uint64() * inverse) & mask
+
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	names := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	var prefix strings.Builder
+	for len(names) < n {
+		prefix.Reset()
+		for range 8 + rng.IntN(8) {
+			prefix.WriteByte(letters[rng.IntN(len(letters))])
+		}
+		h := fnv1a(prefix.String())
+		if (h^want)&mask&^0xff != 0 {
+			continue
+		}
+		b := byte((h ^ want) & 0xff)
+		if b < ' ' || b > '~' || b == ';' {
+			continue
+		}
+		if name := prefix.String() + string(b); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,146 @@
+package gen
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"onebrc"
+	"onebrc/engine/split"
+)
+
+func generateMode(t *testing.T, mode string, stations []Station, rows int64) (*Generator, onebrc.Result, []byte) {
+	t.Helper()
+
+	g := &Generator{Stations: stations, StdDev: 10, Seed: 7, Workers: 3}
+	if err := g.Configure(mode); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := g.Write(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	again := &Generator{Stations: stations, StdDev: 10, Seed: 7, Workers: 1}
+	if err := again.Configure(mode); err != nil {
+		t.Fatal(err)
+	}
+	var againBuf bytes.Buffer
+	if err := again.Write(&againBuf, rows); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), againBuf.Bytes()) {
+		t.Fatalf("%s: same seed produced different output", mode)
+	}
+
+	result := make(onebrc.Result)
+	if err := split.ParseBlock(buf.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	return g, result, buf.Bytes()
+}
+
+func loadStations(t *testing.T) []Station {
+	t.Helper()
+
+	stations, err := LoadStations(stationsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stations[:MaxStations]
+}
+
+func TestModeLongUTF8(t *testing.T) {
+	_, result, _ := generateMode(t, "long-utf8", loadStations(t)[:500], 10_000)
+	for name := range result {
+		if len(name) != 100 || !utf8.ValidString(name) || utf8.RuneCountInString(name) == 100 {
+			t.Fatalf("Not a 100 byte multibyte name: %q (%d bytes)", name, len(name))
+		}
+	}
+}
+
+func TestModeUnique10k(t *testing.T) {
+	_, result, _ := generateMode(t, "unique-10k", loadStations(t), 20_000)
+	if len(result) != MaxStations {
+		t.Errorf("Expected %d stations, got %d", MaxStations, len(result))
+	}
+}
+
+func TestModeFNVCollide(t *testing.T) {
+	_, result, _ := generateMode(t, "fnv-collide", loadStations(t)[:300], 1_000)
+	if len(result) != 300 {
+		t.Errorf("Expected 300 stations, got %d", len(result))
+	}
+
+	buckets := make(map[uint64]int)
+	for name := range result {
+		buckets[fnv1a(name)&(1<<14-1)]++
+	}
+	if len(buckets) != 1 {
+		t.Errorf("Expected a single bucket, got %d", len(buckets))
+	}
+}
+
+func TestModeBoundaries(t *testing.T) {
+	_, _, data := generateMode(t, "boundaries", loadStations(t)[:10], 1999)
+
+	seen := make(map[int64]bool)
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		temp, ok := split.ParseTenths(line[bytes.IndexByte(line, ';')+1:])
+		if !ok {
+			t.Fatalf("Invalid line %q", line)
+		}
+		seen[temp] = true
+	}
+	for temp := int64(-999); temp <= 999; temp++ {
+		if !seen[temp] {
+			t.Fatalf("Missing value %d", temp)
+		}
+	}
+}
+
+func TestModeSkewed(t *testing.T) {
+	stations := loadStations(t)[:1000]
+	_, result, _ := generateMode(t, "skewed", stations, 100_000)
+
+	counts := make([]int, 0, len(result))
+	for _, s := range result {
+		counts = append(counts, int(s.Count))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	if top := result[stations[0].Name].Count; top != int64(counts[0]) || counts[0] < 100*counts[len(counts)/2] {
+		t.Errorf("Not skewed: top %d, counts %v...", top, counts[:10])
+	}
+}
+
+func TestModeUTF16Order(t *testing.T) {
+	_, result, _ := generateMode(t, "utf16-order", loadStations(t)[:100], 1_000)
+	if len(result) != 100 {
+		t.Errorf("Expected 100 stations, got %d", len(result))
+	}
+
+	// the reference order, by the code units of the UTF-16 encoded names
+	expected := result.SortedNames(onebrc.Bytes)
+	sort.Slice(expected, func(i, j int) bool {
+		return slices.Compare(utf16.Encode([]rune(expected[i])), utf16.Encode([]rune(expected[j]))) < 0
+	})
+	if actual := result.Names(); !slices.Equal(actual, expected) {
+		t.Errorf("Names not in UTF-16 order:\n%q\nexpected\n%q", actual, expected)
+	}
+	if slices.Equal(result.SortedNames(onebrc.Bytes), expected) {
+		t.Error("Byte order and UTF-16 order agree")
+	}
+}
+
+func TestUnknownMode(t *testing.T) {
+	g := &Generator{Stations: []Station{{"a", 1}}}
+	if err := g.Configure("nope"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+	if err := g.Configure("unique-10k"); err == nil {
+		t.Error("Expected an error for too few stations")
+	}
+}
@@ -0,0 +1,233 @@
+// Package incremental keeps the aggregates of an append-only measurements
+// file up to date without rescanning it. A state file remembers how far the
+// file was processed, a fingerprint of that prefix and the aggregates so far.
+// A rerun only parses the complete lines appended since. A trailing line
+// without newline is included in the result like in a one-shot run, but left
+// out of the state, so the next run reads it again once it is complete. If
+// the file was
+// truncated or rewritten the fingerprint no longer matches and the whole file
+// is scanned again.
+package incremental
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"onebrc"
+	"onebrc/engine/split"
+	"onebrc/snapshot"
+)
+
+// StateTag is the snapshot extension tag of the incremental state.
+const StateTag = 1
+
+const (
+	// fingerprintBlock bytes are hashed at the start, at the end and at
+	// fingerprintSamples evenly spaced positions of the processed prefix.
+	// Sampling keeps reruns cheap, rewrites that preserve every sampled
+	// block and the length are not detected.
+	fingerprintBlock   = 64 * 1024
+	fingerprintSamples = 16
+)
+
+// Info describes what an update did.
+type Info struct {
+	FullScan bool   // the whole file was scanned
+	Reason   string // why a full scan was needed
+	From, To int64  // the byte range processed and saved in the state
+}
+
+// Update brings the state at statePath up to date with the file at path and
+// returns the aggregates of all lines. A trailing line without newline is
+// part of the result but not of the state, it is read again by the next
+// update.
+func Update(path, statePath string, workers int) (onebrc.Result, Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, Info{}, err
+	}
+	size := st.Size()
+
+	var info Info
+	result, offset, reason, err := resume(f, size, statePath)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if reason != "" {
+		info.FullScan, info.Reason = true, reason
+		result, offset = make(onebrc.Result), 0
+	}
+
+	end, err := lastLineEnd(f, offset, size)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info.From, info.To = offset, end
+
+	if err := parseRange(f, offset, end, workers, result); err != nil {
+		return nil, Info{}, err
+	}
+
+	fingerprint, err := fingerprintOf(f, end)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if err := saveState(statePath, result, end, fingerprint); err != nil {
+		return nil, Info{}, err
+	}
+
+	if end < size {
+		tail := make([]byte, size-end)
+		if _, err := f.ReadAt(tail, end); err != nil {
+			return nil, Info{}, err
+		}
+		if err := split.ParseBlock(tail, result); err != nil {
+			return nil, Info{}, err
+		}
+	}
+	return result, info, nil
+}
+
+// resume loads the state and checks it against the file. A non-empty reason
+// means the state cannot be used.
+func resume(f io.ReaderAt, size int64, statePath string) (onebrc.Result, int64, string, error) {
+	s, err := snapshot.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, "no previous state", nil
+	}
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	var data []byte
+	for _, ext := range s.Extensions {
+		if ext.Tag == StateTag {
+			data = ext.Data
+		}
+	}
+	if len(data) != 8+sha256.Size {
+		return nil, 0, "", fmt.Errorf("%s: missing incremental state", statePath)
+	}
+	offset := int64(binary.BigEndian.Uint64(data))
+	if offset > size {
+		return nil, 0, "file was truncated", nil
+	}
+	fingerprint, err := fingerprintOf(f, offset)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if !bytes.Equal(fingerprint, data[8:]) {
+		return nil, 0, "file was rewritten", nil
+	}
+	return s.Result, offset, "", nil
+}
+
+// lastLineEnd returns the offset just past the last newline in [from, size),
+// or from if there is none.
+func lastLineEnd(f io.ReaderAt, from, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > from; {
+		start := max(end-int64(len(buf)), from)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return from, nil
+}
+
+func parseRange(f io.ReaderAt, from, to int64, workers int, result onebrc.Result) error {
+	section := io.NewSectionReader(f, from, to-from)
+	parts, err := split.SplitFile(section, to-from, workers)
+	if err != nil {
+		return err
+	}
+
+	results := make([]onebrc.Result, len(parts))
+	errs := make(chan error, len(parts))
+	for i, p := range parts {
+		go func() {
+			results[i] = make(onebrc.Result)
+			errs <- split.ParseReader(io.NewSectionReader(section, p.Offset, p.Size), results[i])
+		}()
+	}
+	for range parts {
+		if e := <-errs; e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		result.Merge(r)
+	}
+	return nil
+}
+
+// fingerprintOf hashes the length and sampled blocks of the first n bytes.
+func fingerprintOf(f io.ReaderAt, n int64) ([]byte, error) {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, n)
+
+	buf := make([]byte, fingerprintBlock)
+	hashBlock := func(offset int64) error {
+		offset = min(max(offset, 0), max(n-fingerprintBlock, 0))
+		m, err := f.ReadAt(buf[:min(fingerprintBlock, n-offset)], offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		h.Write(buf[:m])
+		return nil
+	}
+
+	if err := hashBlock(0); err != nil {
+		return nil, err
+	}
+	if n > fingerprintBlock {
+		for i := int64(1); i <= fingerprintSamples; i++ {
+			if err := hashBlock(n * i / (fingerprintSamples + 1)); err != nil {
+				return nil, err
+			}
+		}
+		if err := hashBlock(n - fingerprintBlock); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// saveState replaces the state file atomically.
+func saveState(statePath string, result onebrc.Result, offset int64, fingerprint []byte) error {
+	data := binary.BigEndian.AppendUint64(nil, uint64(offset))
+	data = append(data, fingerprint...)
+
+	tmp, err := os.CreateTemp(filepath.Dir(statePath), filepath.Base(statePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	s := &snapshot.Snapshot{Result: result, Extensions: []snapshot.Extension{{Tag: StateTag, Data: data}}}
+	if err := snapshot.WriteFile(tmp.Name(), s); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), statePath)
+}
@@ -0,0 +1,124 @@
+package incremental
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"onebrc"
+	"onebrc/engine/split"
+)
+
+func appendTo(t *testing.T, path, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func update(t *testing.T, path, statePath string) (onebrc.Result, Info) {
+	t.Helper()
+
+	result, info, err := Update(path, statePath, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, info
+}
+
+func checkMatchesFullScan(t *testing.T, path string, result onebrc.Result) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// like a one-shot run, including a trailing line without newline
+	expected := make(onebrc.Result)
+	if err := split.ParseBlock(data, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d stations, got %d", len(expected), len(result))
+	}
+	for name, e := range expected {
+		if a := result[name]; a == nil || *a != *e {
+			t.Fatalf("%s: expected %+v, got %+v", name, *e, a)
+		}
+	}
+}
+
+func TestUpdateProcessesOnlyAppendedLines(t *testing.T) {
+	dir := t.TempDir()
+	path, statePath := filepath.Join(dir, "measurements.txt"), filepath.Join(dir, "state")
+
+	appendTo(t, path, "a;1.0\nb;2.0\nc;3.5")
+	result, info := update(t, path, statePath)
+	if !info.FullScan || info.From != 0 || info.To != 12 {
+		t.Errorf("Unexpected first update: %+v", info)
+	}
+	checkMatchesFullScan(t, path, result)
+
+	// the trailing line is printed but not saved, so it is counted once
+	// after its newline arrives
+	result, _ = update(t, path, statePath)
+	checkMatchesFullScan(t, path, result)
+
+	// completes the partial line and adds more
+	appendTo(t, path, "\na;-4.0\n")
+	result, info = update(t, path, statePath)
+	if info.FullScan || info.From != 12 || info.To != 25 {
+		t.Errorf("Unexpected incremental update: %+v", info)
+	}
+	checkMatchesFullScan(t, path, result)
+
+	// nothing new
+	result, info = update(t, path, statePath)
+	if info.FullScan || info.From != info.To {
+		t.Errorf("Unexpected empty update: %+v", info)
+	}
+	checkMatchesFullScan(t, path, result)
+
+	// a large append that needs several parts
+	appendTo(t, path, strings.Repeat("d;12.3\ne;-0.1\nf;99.9\n", 100_000))
+	result, info = update(t, path, statePath)
+	if info.FullScan {
+		t.Errorf("Unexpected full scan: %+v", info)
+	}
+	checkMatchesFullScan(t, path, result)
+}
+
+func TestUpdateDetectsTruncationAndRewrite(t *testing.T) {
+	dir := t.TempDir()
+	path, statePath := filepath.Join(dir, "measurements.txt"), filepath.Join(dir, "state")
+
+	appendTo(t, path, strings.Repeat("a;1.0\nb;2.0\n", 50_000))
+	update(t, path, statePath)
+
+	if err := os.WriteFile(path, []byte("a;5.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, info := update(t, path, statePath)
+	if !info.FullScan || info.Reason != "file was truncated" {
+		t.Errorf("Expected a full scan after truncation, got %+v", info)
+	}
+	checkMatchesFullScan(t, path, result)
+
+	// same length, different content
+	if err := os.WriteFile(path, []byte("a;6.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, info = update(t, path, statePath)
+	if !info.FullScan || info.Reason != "file was rewritten" {
+		t.Errorf("Expected a full scan after rewrite, got %+v", info)
+	}
+	checkMatchesFullScan(t, path, result)
+}
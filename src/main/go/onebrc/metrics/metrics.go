@@ -0,0 +1,223 @@
+// Package metrics writes per-station statistics and processing metrics in the
+// Prometheus text exposition format, without a client library:
+//
+//	station_temperature_min{station="..."}   gauge, degrees
+//	station_temperature_max{station="..."}   gauge, degrees
+//	station_temperature_mean{station="..."}  gauge, degrees
+//	station_temperature_sum{station="..."}   gauge, degrees
+//	station_temperature_count{station="..."} counter
+//	onebrc_bytes_read_total                  counter
+//	onebrc_lines_parsed_total                counter
+//	onebrc_lines_rejected_total              counter
+//	onebrc_chunk_duration_seconds            histogram
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"onebrc"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets are the upper bounds of the chunk latency histogram
+// in seconds, from 100µs to about 6.5s.
+var DefaultLatencyBuckets = exponentialBuckets(0.0001, 4, 9)
+
+func exponentialBuckets(start, factor float64, n int) []float64 {
+	bounds := make([]float64, n)
+	for i := range bounds {
+		bounds[i] = start
+		start *= factor
+	}
+	return bounds
+}
+
+// Counter is a monotonically increasing value safe for concurrent use.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Histogram counts observations in buckets with fixed upper bounds. It is
+// safe for concurrent use.
+type Histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+}
+
+// NewHistogram returns a histogram with the given sorted upper bounds.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe adds one observation.
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// Processing collects the metrics of a parser. A nil *Processing discards
+// everything, so parsers can take one optionally.
+type Processing struct {
+	BytesRead     Counter
+	LinesParsed   Counter
+	LinesRejected Counter
+	ChunkLatency  *Histogram
+}
+
+// NewProcessing returns empty processing metrics.
+func NewProcessing() *Processing {
+	return &Processing{ChunkLatency: NewHistogram(DefaultLatencyBuckets)}
+}
+
+// Read records n bytes read from the input.
+func (p *Processing) Read(n int) {
+	if p != nil {
+		p.BytesRead.Add(int64(n))
+	}
+}
+
+// Chunk records a parsed chunk of lines that took d.
+func (p *Processing) Chunk(lines int64, d time.Duration) {
+	if p != nil {
+		p.LinesParsed.Add(lines)
+		p.ChunkLatency.Observe(d.Seconds())
+	}
+}
+
+// Reject records n rejected lines.
+func (p *Processing) Reject(n int64) {
+	if p != nil {
+		p.LinesRejected.Add(n)
+	}
+}
+
+// Write writes the processing metrics to w.
+func (p *Processing) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	writeHeader(bw, "onebrc_bytes_read_total", "counter", "Bytes read from the input.")
+	fmt.Fprintf(bw, "onebrc_bytes_read_total %d\n", p.BytesRead.Value())
+	writeHeader(bw, "onebrc_lines_parsed_total", "counter", "Lines aggregated.")
+	fmt.Fprintf(bw, "onebrc_lines_parsed_total %d\n", p.LinesParsed.Value())
+	writeHeader(bw, "onebrc_lines_rejected_total", "counter", "Malformed lines rejected.")
+	fmt.Fprintf(bw, "onebrc_lines_rejected_total %d\n", p.LinesRejected.Value())
+
+	h := p.ChunkLatency
+	h.mu.Lock()
+	counts, sum := append([]uint64(nil), h.counts...), h.sum
+	h.mu.Unlock()
+
+	const name = "onebrc_chunk_duration_seconds"
+	writeHeader(bw, name, "histogram", "Time to parse one chunk.")
+	var cumulative uint64
+	for i, count := range counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", name, le, cumulative)
+	}
+	fmt.Fprintf(bw, "%s_sum %s\n", name, formatFloat(sum))
+	fmt.Fprintf(bw, "%s_count %d\n", name, cumulative)
+
+	return bw.Flush()
+}
+
+// WriteStations writes the per-station statistics of r to w, sorted by
+// station name.
+func WriteStations(w io.Writer, r onebrc.Result) error {
+	bw := bufio.NewWriter(w)
+	names := r.Names()
+	labels := make([]string, len(names))
+	for i, name := range names {
+		labels[i] = `{station="` + EscapeLabel(name) + `"}`
+	}
+
+	for _, m := range []struct {
+		name, typ, help string
+		value           func(*onebrc.Stats) string
+	}{
+		{"station_temperature_min", "gauge", "Lowest temperature in degrees Celsius.", func(s *onebrc.Stats) string { return formatTenths(s.Min) }},
+		{"station_temperature_max", "gauge", "Highest temperature in degrees Celsius.", func(s *onebrc.Stats) string { return formatTenths(s.Max) }},
+		{"station_temperature_mean", "gauge", "Mean temperature in degrees Celsius.", func(s *onebrc.Stats) string { return formatFloat(onebrc.Round(s.Mean())) }},
+		{"station_temperature_sum", "gauge", "Sum of all temperatures in degrees Celsius.", func(s *onebrc.Stats) string { return formatTenths(s.Sum) }},
+		{"station_temperature_count", "counter", "Number of measurements.", func(s *onebrc.Stats) string { return strconv.FormatInt(s.Count, 10) }},
+	} {
+		writeHeader(bw, m.name, m.typ, m.help)
+		for i, name := range names {
+			fmt.Fprintf(bw, "%s%s %s\n", m.name, labels[i], m.value(r[name]))
+		}
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// EscapeLabel escapes a label value: backslash, double quote and line feed
+// are written as \\, \" and \n.
+func EscapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatTenths(v int64) string {
+	return formatFloat(float64(v) / 10)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves the statistics returned by result, which must not be
+// modified afterwards, and the processing metrics p, if not nil.
+func Handler(result func() onebrc.Result, p *Processing) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := WriteStations(w, result()); err != nil {
+			return
+		}
+		if p != nil {
+			p.Write(w)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"onebrc"
+)
+
+func TestEscapeLabel(t *testing.T) {
+	for in, expected := range map[string]string{
+		"Hamburg":         "Hamburg",
+		`São "Paulo"`:     `São \"Paulo\"`,
+		`back\slash`:      `back\\slash`,
+		"line\nfeed":      `line\nfeed`,
+		`\"` + "\n" + `"`: `\\\"\n\"`,
+	} {
+		if actual := EscapeLabel(in); actual != expected {
+			t.Errorf("%q: expected %s, got %s", in, expected, actual)
+		}
+	}
+}
+
+func TestWriteStations(t *testing.T) {
+	r := onebrc.Result{
+		"b":          {Min: -25, Max: 10, Sum: -15, Count: 2},
+		`a"\` + "\n": {Min: 5, Max: 5, Sum: 5, Count: 1},
+	}
+	var b strings.Builder
+	if err := WriteStations(&b, r); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP station_temperature_min Lowest temperature in degrees Celsius.
+# TYPE station_temperature_min gauge
+station_temperature_min{station="a\"\\\n"} 0.5
+station_temperature_min{station="b"} -2.5
+# HELP station_temperature_max Highest temperature in degrees Celsius.
+# TYPE station_temperature_max gauge
+station_temperature_max{station="a\"\\\n"} 0.5
+station_temperature_max{station="b"} 1
+# HELP station_temperature_mean Mean temperature in degrees Celsius.
+# TYPE station_temperature_mean gauge
+station_temperature_mean{station="a\"\\\n"} 0.5
+station_temperature_mean{station="b"} -0.7
+# HELP station_temperature_sum Sum of all temperatures in degrees Celsius.
+# TYPE station_temperature_sum gauge
+station_temperature_sum{station="a\"\\\n"} 0.5
+station_temperature_sum{station="b"} -1.5
+# HELP station_temperature_count Number of measurements.
+# TYPE station_temperature_count counter
+station_temperature_count{station="a\"\\\n"} 1
+station_temperature_count{station="b"} 2
+`
+	if b.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestProcessing(t *testing.T) {
+	p := NewProcessing()
+	p.Read(100)
+	p.Read(20)
+	p.Chunk(7, 50*time.Microsecond)
+	p.Chunk(3, 2*time.Millisecond)
+	p.Reject(1)
+
+	var nilMetrics *Processing
+	nilMetrics.Read(1) // must not panic
+	nilMetrics.Chunk(1, time.Second)
+	nilMetrics.Reject(1)
+
+	var b strings.Builder
+	if err := p.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"onebrc_bytes_read_total 120\n",
+		"onebrc_lines_parsed_total 10\n",
+		"onebrc_lines_rejected_total 1\n",
+		"# TYPE onebrc_chunk_duration_seconds histogram\n",
+		`onebrc_chunk_duration_seconds_bucket{le="0.0001"} 1` + "\n",
+		`onebrc_chunk_duration_seconds_bucket{le="0.0016"} 1` + "\n",
+		`onebrc_chunk_duration_seconds_bucket{le="0.0064"} 2` + "\n",
+		`onebrc_chunk_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"onebrc_chunk_duration_seconds_sum 0.00205\n",
+		"onebrc_chunk_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Missing %q in\n%s", line, b.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := onebrc.Result{"x": {Min: 1, Max: 1, Sum: 1, Count: 1}}
+	h := Handler(func() onebrc.Result { return r }, NewProcessing())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Fatalf("Expected content type %q, got %q", ContentType, ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{`station_temperature_count{station="x"} 1`, "onebrc_lines_parsed_total 0"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Missing %q in\n%s", line, body)
+		}
+	}
+}
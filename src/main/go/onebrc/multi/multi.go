@@ -0,0 +1,220 @@
+// Package multi aggregates many files in one run, e.g. a directory of hourly
+// files. The files are cut into newline aligned chunks which are all put
+// into one queue shared by the workers, so a few large files and many small
+// ones keep every worker busy alike. A compressed file is a single task, the
+// members of a multi-member gzip file are still decompressed in parallel.
+package multi
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"onebrc"
+	"onebrc/engine/compressed"
+	"onebrc/engine/split"
+)
+
+// DefaultChunkSize is the size of the chunks files are cut into.
+const DefaultChunkSize = 16 * 1024 * 1024
+
+// Expand resolves args into a list of files. An arg may be a file, a shell
+// pattern as understood by filepath.Match or a directory, which is walked
+// recursively. Hidden files and directories and gzip index files inside
+// directories are skipped. Every file is listed once, in the order found.
+func Expand(args []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+
+		for _, match := range matches {
+			st, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !st.IsDir() {
+				add(match)
+				continue
+			}
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				hidden := path != match && strings.HasPrefix(d.Name(), ".")
+				switch {
+				case d.IsDir() && hidden:
+					return filepath.SkipDir
+				case d.Type().IsRegular() && !hidden && !strings.HasSuffix(path, ".idx"):
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// task is a range of a file, or a whole compressed file if size is negative.
+type task struct {
+	file         int
+	offset, size int64
+}
+
+// Aggregate aggregates all files with opts.NumWorkers() workers. opts.ChunkSize
+// overrides DefaultChunkSize. With perFile it also returns every file's own
+// result, keyed by path.
+func Aggregate(paths []string, opts onebrc.Options, perFile bool) (onebrc.Result, map[string]onebrc.Result, error) {
+	chunkSize := int64(opts.ChunkSize)
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	tasks, err := plan(paths, chunkSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tasksCh := make(chan task, len(tasks))
+	for _, t := range tasks {
+		tasksCh <- t
+	}
+	close(tasksCh)
+
+	workers := opts.NumWorkers()
+	results := make([]map[int]onebrc.Result, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := range results {
+		results[w] = make(map[int]onebrc.Result)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for t := range tasksCh {
+				if errs[w] != nil {
+					continue // drain the queue
+				}
+				result, err := run(paths[t.file], t, opts)
+				if err != nil {
+					errs[w] = fmt.Errorf("%s: %w", paths[t.file], err)
+					continue
+				}
+				// without a breakdown everything is merged into one result
+				key := 0
+				if perFile {
+					key = t.file
+				}
+				if r := results[w][key]; r != nil {
+					r.Merge(result)
+				} else {
+					results[w][key] = result
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	total := make(onebrc.Result)
+	var files map[string]onebrc.Result
+	if perFile {
+		files = make(map[string]onebrc.Result, len(paths))
+		for _, path := range paths {
+			files[path] = make(onebrc.Result)
+		}
+	}
+	for _, wr := range results {
+		for file, r := range wr {
+			if perFile {
+				files[paths[file]].Merge(r.Clone())
+			}
+			total.Merge(r)
+		}
+	}
+	return total, files, nil
+}
+
+// plan cuts every file into tasks of about chunkSize bytes.
+func plan(paths []string, chunkSize int64) ([]task, error) {
+	var tasks []task
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		format, err := compressed.Detect(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if format != compressed.Plain {
+			f.Close()
+			tasks = append(tasks, task{file: i, size: -1})
+			continue
+		}
+
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		parts, err := split.SplitFile(f, st.Size(), int((st.Size()+chunkSize-1)/chunkSize))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, p := range parts {
+			tasks = append(tasks, task{file: i, offset: p.Offset, size: p.Size})
+		}
+	}
+	return tasks, nil
+}
+
+func run(path string, t task, opts onebrc.Options) (onebrc.Result, error) {
+	if t.size < 0 {
+		return compressed.Wrap(split.New(opts), opts).Aggregate(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make(onebrc.Result)
+	err = split.ReadBlocks(io.NewSectionReader(f, t.offset, t.size), func(block []byte) error {
+		return split.ParseBlockWith(block, result, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package multi
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"onebrc"
+	"onebrc/engine/split"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a.txt", "b.txt", "c.csv",
+		"hourly/00.txt", "hourly/01.txt", "hourly/deep/02.txt",
+		"hourly/.hidden.txt", "hourly/.git/x.txt", "hourly/03.txt.gz", "hourly/03.txt.gz.idx",
+	} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+
+	files, err := Expand([]string{
+		filepath.Join(dir, "*.txt"),
+		filepath.Join(dir, "hourly"),
+		filepath.Join(dir, "a.txt"), // already listed
+		filepath.Join(dir, "c.csv"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i], _ = filepath.Rel(dir, files[i])
+	}
+	expected := "[a.txt b.txt hourly/00.txt hourly/01.txt hourly/03.txt.gz hourly/deep/02.txt c.csv]"
+	if fmt.Sprint(files) != expected {
+		t.Fatalf("Expected %s, got %v", expected, files)
+	}
+
+	if _, err := Expand([]string{filepath.Join(dir, "*.nope")}); err == nil {
+		t.Error("Expected an error for a pattern without matches")
+	}
+	if _, err := Expand([]string{filepath.Join(dir, "missing.txt")}); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{
+		"00.txt": strings.Repeat("Hamburg;12.0\nBulawayo;8.9\n", 50),
+		"01.txt": "Hamburg;-3.4\n",
+		"02.txt": "",
+		"03.txt": strings.Repeat("Palembang;38.8\nHamburg;1.0\n", 30) + "Bulawayo;-1.5",
+	}
+	var paths []string
+	for _, name := range []string{"00.txt", "01.txt", "02.txt", "03.txt"} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, contents[name])
+		paths = append(paths, path)
+	}
+	// and a compressed one
+	gzPath := filepath.Join(dir, "04.txt.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte("Hamburg;40.0\n"))
+	zw.Close()
+	f.Close()
+	contents["04.txt.gz"] = "Hamburg;40.0\n"
+	paths = append(paths, gzPath)
+
+	expected := make(map[string]onebrc.Result)
+	expectedTotal := make(onebrc.Result)
+	for _, path := range paths {
+		r := make(onebrc.Result)
+		if err := split.ParseBlock([]byte(contents[filepath.Base(path)]), r); err != nil {
+			t.Fatal(err)
+		}
+		expected[path] = r
+		expectedTotal.Merge(r.Clone())
+	}
+
+	for _, opts := range []onebrc.Options{{}, {Workers: 3, ChunkSize: 20}, {Workers: 8, ChunkSize: 1}} {
+		total, files, err := Aggregate(paths, opts, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEqual(t, "total", expectedTotal, total)
+		if len(files) != len(paths) {
+			t.Fatalf("Expected %d files, got %d", len(paths), len(files))
+		}
+		for _, path := range paths {
+			checkEqual(t, path, expected[path], files[path])
+		}
+
+		total, files, err = Aggregate(paths, opts, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEqual(t, "total", expectedTotal, total)
+		if files != nil {
+			t.Errorf("Expected no per-file results, got %v", files)
+		}
+	}
+}
+
+func TestAggregateError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.txt")
+	writeFile(t, path, "Hamburg;12\n")
+	if _, _, err := Aggregate([]string{path}, onebrc.Options{}, false); err == nil {
+		t.Fatal("Expected an error for a malformed file")
+	}
+}
+
+func checkEqual(t *testing.T, name string, expected, actual onebrc.Result) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("%s: expected %d stations, got %d", name, len(expected), len(actual))
+	}
+	for station, e := range expected {
+		if a := actual[station]; a == nil || *a != *e {
+			t.Fatalf("%s: %s: expected %+v, got %+v", name, station, *e, a)
+		}
+	}
+}
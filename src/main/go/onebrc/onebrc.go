@@ -0,0 +1,197 @@
+// Package onebrc holds the pieces shared by the Go implementations of the
+// One Billion Row Challenge: the per-station aggregate, the merged result and
+// the interfaces implemented by the engines and output formatters.
+package onebrc
+
+import (
+	"math"
+	"math/big"
+	"runtime"
+
+	"onebrc/tdigest"
+)
+
+// MaxStations is the maximum number of distinct stations allowed by the spec.
+const MaxStations = 10000
+
+// Stats is the aggregate of one station. Temperatures are kept as integer
+// tenths of a degree, so aggregates are exact and merging is associative.
+// SumSquares is the sum of the squared temperatures for StdDev, -1 if
+// unknown, e.g. for stations restored from an old snapshot. Digest, if not
+// nil, also sketches the distribution for quantiles, see Options.Compression.
+type Stats struct {
+	Min, Max, Sum, Count int64
+	SumSquares           int64
+	Digest               *tdigest.Digest
+}
+
+// Add records a single temperature given in tenths of a degree. Unknown
+// SumSquares stay unknown.
+func (s *Stats) Add(temp int64) {
+	if s.Digest != nil {
+		s.Digest.Add(float64(temp))
+	}
+	if s.Count == 0 {
+		s.Min = temp
+		s.Max = temp
+	} else {
+		s.Min = min(s.Min, temp)
+		s.Max = max(s.Max, temp)
+	}
+	s.Sum += temp
+	if s.SumSquares >= 0 {
+		s.SumSquares += temp * temp
+	}
+	s.Count++
+}
+
+// Merge folds o into s. o is not modified.
+func (s *Stats) Merge(o *Stats) {
+	if o.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		*s = *o
+		if o.Digest != nil {
+			s.Digest = o.Digest.Clone()
+		}
+		return
+	}
+	if o.Digest != nil {
+		if s.Digest == nil {
+			s.Digest = tdigest.New(o.Digest.Compression())
+		}
+		s.Digest.Merge(o.Digest)
+	}
+	s.Min = min(s.Min, o.Min)
+	s.Max = max(s.Max, o.Max)
+	s.Sum += o.Sum
+	if s.SumSquares < 0 || o.SumSquares < 0 {
+		s.SumSquares = -1
+	} else {
+		s.SumSquares += o.SumSquares
+	}
+	s.Count += o.Count
+}
+
+// Mean returns the mean temperature in degrees.
+func (s *Stats) Mean() float64 {
+	return float64(s.Sum) / 10.0 / float64(s.Count)
+}
+
+// StdDev returns the sample standard deviation of the temperatures in
+// degrees, NaN for fewer than two rows or unknown SumSquares. The variance
+// is (Count*SumSquares - Sum*Sum) / (Count*(Count-1)), with the numerator
+// computed in exact integers: in floating point the two terms nearly cancel.
+func (s *Stats) StdDev() float64 {
+	if s.Count < 2 || s.SumSquares < 0 {
+		return math.NaN()
+	}
+	n := big.NewInt(s.Count)
+	num := new(big.Int).Mul(n, big.NewInt(s.SumSquares))
+	sum := big.NewInt(s.Sum)
+	num.Sub(num, sum.Mul(sum, sum))
+	den := new(big.Int).Mul(n, big.NewInt(s.Count-1))
+	variance, _ := new(big.Rat).SetFrac(num, den).Float64()
+	return math.Sqrt(variance) / 10.0
+}
+
+// Quantile returns an estimate of the temperature in degrees at quantile q,
+// NaN without a digest.
+func (s *Stats) Quantile(q float64) float64 {
+	if s.Digest == nil {
+		return math.NaN()
+	}
+	return s.Digest.Quantile(q) / 10.0
+}
+
+// Result maps station names to their aggregates.
+type Result map[string]*Stats
+
+// Merge folds every station of o into r. Stats that are new to r are taken
+// over from o, so o must not be used afterwards.
+func (r Result) Merge(o Result) {
+	for name, os := range o {
+		if s := r[name]; s == nil {
+			r[name] = os
+		} else {
+			s.Merge(os)
+		}
+	}
+}
+
+// Clone returns a deep copy of r.
+func (r Result) Clone() Result {
+	c := make(Result, len(r))
+	for name, s := range r {
+		copied := *s
+		if s.Digest != nil {
+			copied.Digest = s.Digest.Clone()
+		}
+		c[name] = &copied
+	}
+	return c
+}
+
+// Rows returns the total number of measurements in r.
+func (r Result) Rows() int64 {
+	var rows int64
+	for _, s := range r {
+		rows += s.Count
+	}
+	return rows
+}
+
+// Names returns the station names of r in output order, the UTF16
+// collation of the reference implementation.
+func (r Result) Names() []string {
+	return r.SortedNames(UTF16)
+}
+
+// SortedNames returns the station names of r ordered by c.
+func (r Result) SortedNames(c Collation) []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	c.Sort(names)
+	return names
+}
+
+// Aggregator computes the per-station aggregates of a measurements file.
+type Aggregator interface {
+	Aggregate(path string) (Result, error)
+}
+
+// Options tune an engine. Zero values select the engine defaults.
+type Options struct {
+	// Workers is the number of concurrent parsers, runtime.NumCPU() if unset.
+	Workers int
+	// ChunkSize is the number of bytes handed to a parser at a time, for the
+	// engines that process the file in fixed size chunks.
+	ChunkSize int
+	// Compression is the t-digest compression δ of the per-station
+	// quantile sketches. Zero disables them.
+	Compression float64
+	// Filter, if not nil, selects the rows aggregated.
+	Filter *Filter
+	// Aliases, if not nil, folds station names into canonical ones.
+	Aliases *Aliases
+}
+
+// NewDigest returns an empty digest for a new station, nil if quantiles are
+// disabled.
+func (o Options) NewDigest() *tdigest.Digest {
+	if o.Compression <= 0 {
+		return nil
+	}
+	return tdigest.New(o.Compression)
+}
+
+// NumWorkers returns o.Workers or its default.
+func (o Options) NumWorkers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.NumCPU()
+}
@@ -0,0 +1,174 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"onebrc"
+)
+
+type columnKind int
+
+const (
+	colStation columnKind = iota
+	colMin
+	colMax
+	colMean
+	colSum
+	colCount
+	colRange
+	colQuantile
+)
+
+// Columns are the column names a query can reference besides quantiles,
+// which are written pNN for the NN-th percentile, e.g. p50, p95 or p99.9.
+var Columns = []string{"station", "min", "max", "mean", "sum", "count", "range"}
+
+// column is a statistic of a station.
+type column struct {
+	name string
+	kind columnKind
+	q    float64 // quantile in [0, 1] for colQuantile
+}
+
+// lookupColumn resolves the column named name, ignoring case.
+func lookupColumn(name string, pos int) (column, error) {
+	lower := strings.ToLower(name)
+	for i, c := range Columns {
+		if lower == c {
+			return column{name: c, kind: columnKind(i)}, nil
+		}
+	}
+	if lower == "name" {
+		return column{name: "station", kind: colStation}, nil
+	}
+	if len(lower) > 1 && lower[0] == 'p' && lower[1] >= '0' && lower[1] <= '9' {
+		// parsing with the exponent rounds p99.9 to 0.999, not 99.9 / 100
+		q, err := strconv.ParseFloat(lower[1:]+"e-2", 64)
+		if err != nil || q < 0 || q > 1 {
+			return column{}, errorf(pos, "invalid quantile column %q, want p0 to p100", name)
+		}
+		return column{name: lower, kind: colQuantile, q: q}, nil
+	}
+	msg := fmt.Sprintf("unknown column %q, available: %s, pNN", name, strings.Join(Columns, ", "))
+	if s := suggest(lower); s != "" {
+		msg += fmt.Sprintf(" (did you mean %q?)", s)
+	}
+	return column{}, errorf(pos, "%s", msg)
+}
+
+// suggest returns the column closest to name if it is only a typo away.
+func suggest(name string) string {
+	best, bestDist := "", 3
+	for _, c := range Columns {
+		if d := distance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// distance is the edit distance of a and b, counting insertions,
+// deletions, substitutions and transpositions of adjacent bytes.
+func distance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func (c column) isString() bool {
+	return c.kind == colStation
+}
+
+// value returns the column of the station name with stats s. Temperatures
+// are rounded to one decimal the way they are written, so that a query
+// filters on the numbers it shows.
+func (c column) value(name string, s *onebrc.Stats) value {
+	switch c.kind {
+	case colStation:
+		return value{str: name, isString: true}
+	case colMin:
+		return number(float64(s.Min) / 10)
+	case colMax:
+		return number(float64(s.Max) / 10)
+	case colMean:
+		return number(onebrc.Round(s.Mean()))
+	case colSum:
+		return number(float64(s.Sum) / 10)
+	case colCount:
+		return number(float64(s.Count))
+	case colRange:
+		return number(float64(s.Max-s.Min) / 10)
+	case colQuantile:
+		return number(onebrc.Round(s.Quantile(c.q)))
+	}
+	panic("unknown column kind")
+}
+
+// format formats the column of the station name with stats s for output.
+func (c column) format(name string, s *onebrc.Stats) string {
+	switch c.kind {
+	case colStation:
+		return name
+	case colMin:
+		return onebrc.FormatTenths(s.Min)
+	case colMax:
+		return onebrc.FormatTenths(s.Max)
+	case colMean:
+		return onebrc.FormatTemp(s.Mean())
+	case colSum:
+		return onebrc.FormatTenths(s.Sum)
+	case colCount:
+		return strconv.FormatInt(s.Count, 10)
+	case colRange:
+		return onebrc.FormatTenths(s.Max - s.Min)
+	case colQuantile:
+		return onebrc.FormatTemp(s.Quantile(c.q))
+	}
+	panic("unknown column kind")
+}
+
+// value is a string or a number.
+type value struct {
+	str      string
+	num      float64
+	isString bool
+}
+
+func number(x float64) value {
+	return value{num: x}
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than b,
+// which must be of the same type. Strings compare by c.
+func compare(a, b value, c onebrc.Collation) int {
+	if a.isString {
+		return c.Compare(a.str, b.str)
+	}
+	switch {
+	case a.num < b.num:
+		return -1
+	case a.num > b.num:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp    // = != <> < <= > >=
+	tokComma // ,
+	tokStar  // *
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifiers keep their case, strings are unquoted
+	pos  int    // byte offset in the query
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(t.text, "'", "''"))
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keyword reports whether t is the keyword kw, ignoring case.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+// Error is a syntax or semantic error in a query.
+type Error struct {
+	Pos int // byte offset in the query
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query: column %d: %s", e.Pos+1, e.Msg)
+}
+
+func errorf(pos int, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// lex splits a query into tokens.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", start})
+			i++
+		case r == '*':
+			tokens = append(tokens, token{tokStar, "*", start})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", start})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", start})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			op := s[i : i+1]
+			if i+1 < len(s) && (s[i+1] == '=' || s[i:i+2] == "<>") {
+				op = s[i : i+2]
+			}
+			if op == "!" {
+				return nil, errorf(start, "unexpected '!', did you mean '!='?")
+			}
+			tokens = append(tokens, token{tokOp, op, start})
+			i += len(op)
+		case r == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, errorf(start, "unterminated string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			tokens = append(tokens, token{tokString, b.String(), start})
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			i++
+			for i < len(s) && (s[i] == '.' || s[i] >= '0' && s[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, s[start:i], start})
+		case r == '_' || unicode.IsLetter(r):
+			// quantile columns like p99.9 contain a dot
+			for i < len(s) {
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{tokIdent, s[start:i], start})
+		default:
+			return nil, errorf(start, "unexpected %q", r)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(s)}), nil
+}
@@ -0,0 +1,306 @@
+package query
+
+import (
+	"math"
+	"strconv"
+
+	"onebrc"
+)
+
+// expr is a condition of a WHERE clause.
+type expr interface {
+	eval(name string, s *onebrc.Stats, c onebrc.Collation) bool
+}
+
+type andExpr struct{ left, right expr }
+
+func (e andExpr) eval(name string, s *onebrc.Stats, c onebrc.Collation) bool {
+	return e.left.eval(name, s, c) && e.right.eval(name, s, c)
+}
+
+type orExpr struct{ left, right expr }
+
+func (e orExpr) eval(name string, s *onebrc.Stats, c onebrc.Collation) bool {
+	return e.left.eval(name, s, c) || e.right.eval(name, s, c)
+}
+
+type notExpr struct{ e expr }
+
+func (e notExpr) eval(name string, s *onebrc.Stats, c onebrc.Collation) bool {
+	return !e.e.eval(name, s, c)
+}
+
+// operand is a column or a literal.
+type operand struct {
+	col     *column
+	literal value
+}
+
+func (o operand) value(name string, s *onebrc.Stats) value {
+	if o.col != nil {
+		return o.col.value(name, s)
+	}
+	return o.literal
+}
+
+func (o operand) isString() bool {
+	if o.col != nil {
+		return o.col.isString()
+	}
+	return o.literal.isString
+}
+
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (e comparison) eval(name string, s *onebrc.Stats, c onebrc.Collation) bool {
+	a, b := e.left.value(name, s), e.right.value(name, s)
+	if math.IsNaN(a.num) || math.IsNaN(b.num) {
+		return false
+	}
+	cmp := compare(a, b, c)
+	switch e.op {
+	case "=":
+		return cmp == 0
+	case "!=", "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	panic("unknown operator " + e.op)
+}
+
+// parser is a recursive descent parser of the grammar
+//
+//	query      = SELECT columns [WHERE or] [ORDER BY order {"," order}] [LIMIT integer]
+//	columns    = "*" | column {"," column}
+//	order      = column [ASC | DESC]
+//	or         = and {OR and}
+//	and        = not {AND not}
+//	not        = NOT not | "(" or ")" | operand op operand
+//	operand    = column | number | string
+//	op         = "=" | "!=" | "<>" | "<" | "<=" | ">" | ">="
+//
+// Keywords and column names are case-insensitive. Strings are single
+// quoted, a quote inside a string is doubled.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the keyword kw if it is next.
+func (p *parser) accept(kw string) bool {
+	if p.peek().keyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kw string) error {
+	if !p.accept(kw) {
+		t := p.peek()
+		return errorf(t.pos, "expected %s, found %s", kw, t)
+	}
+	return nil
+}
+
+func (p *parser) query() (*Query, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	q := &Query{Limit: -1}
+	if p.peek().kind == tokStar {
+		p.next()
+		for _, name := range []string{"station", "min", "mean", "max", "count"} {
+			c, _ := lookupColumn(name, 0)
+			q.columns = append(q.columns, c)
+		}
+	} else {
+		for {
+			c, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			q.columns = append(q.columns, c)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.accept("WHERE") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		q.where = e
+	}
+
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			c, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			o := orderItem{col: c}
+			if p.accept("DESC") {
+				o.desc = true
+			} else {
+				p.accept("ASC")
+			}
+			q.orderBy = append(q.orderBy, o)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return nil, errorf(t.pos, "LIMIT wants a non-negative integer, found %s", t)
+		}
+		q.Limit = n
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorf(t.pos, "unexpected %s", t)
+	}
+	return q, nil
+}
+
+func (p *parser) column() (column, error) {
+	t := p.next()
+	if t.kind != tokIdent || isKeyword(t) {
+		return column{}, errorf(t.pos, "expected column, found %s", t)
+	}
+	return lookupColumn(t.text, t.pos)
+}
+
+func (p *parser) or() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("NOT") {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, errorf(t.pos, "expected ')', found %s", t)
+		}
+		return e, nil
+	}
+
+	start := p.peek().pos
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, errorf(op.pos, "expected comparison operator, found %s", op)
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if left.isString() != right.isString() {
+		return nil, errorf(start, "cannot compare a string with a number")
+	}
+	return comparison{op: op.text, left: left, right: right}, nil
+}
+
+func (p *parser) operand() (operand, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		x, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return operand{}, errorf(t.pos, "invalid number %s", t)
+		}
+		return operand{literal: number(x)}, nil
+	case tokString:
+		p.next()
+		return operand{literal: value{str: t.text, isString: true}}, nil
+	}
+	c, err := p.column()
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{col: &c}, nil
+}
+
+var keywords = []string{"SELECT", "WHERE", "AND", "OR", "NOT", "ORDER", "BY", "ASC", "DESC", "LIMIT"}
+
+func isKeyword(t token) bool {
+	for _, kw := range keywords {
+		if t.keyword(kw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+// Package query evaluates a small SQL-like language over the per-station
+// results, e.g.
+//
+//	SELECT station, mean, p95 WHERE count > 1000 AND max > 50 ORDER BY mean DESC LIMIT 10
+//
+// A query selects columns of the statistics every station has: station,
+// min, max, mean, sum, count, range and quantiles written pNN. Quantiles
+// need the t-digests of onebrc.Options.Compression, see Query.Quantiles.
+// Temperatures are compared rounded to one decimal, as they are written.
+package query
+
+import (
+	"bufio"
+	"io"
+	"sort"
+
+	"onebrc"
+)
+
+// Query is a parsed query. It implements onebrc.Formatter by writing the
+// selected rows as a tab separated table with a header line.
+type Query struct {
+	// Limit is the maximum number of rows written, -1 for no limit.
+	Limit int
+	// Collation orders and compares station names.
+	Collation onebrc.Collation
+
+	columns []column
+	where   expr
+	orderBy []orderItem
+}
+
+type orderItem struct {
+	col  column
+	desc bool
+}
+
+// Parse parses a query. Its errors are of type *Error.
+func Parse(s string) (*Query, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.query()
+}
+
+// Header returns the names of the selected columns.
+func (q *Query) Header() []string {
+	header := make([]string, len(q.columns))
+	for i, c := range q.columns {
+		header[i] = c.name
+	}
+	return header
+}
+
+// Quantiles returns the quantiles the query references in any clause, in
+// [0, 1] and without duplicates. Results must carry digests if it is not
+// empty.
+func (q *Query) Quantiles() []float64 {
+	var qs []float64
+	seen := map[float64]bool{}
+	add := func(c column) {
+		if c.kind == colQuantile && !seen[c.q] {
+			seen[c.q] = true
+			qs = append(qs, c.q)
+		}
+	}
+	for _, c := range q.columns {
+		add(c)
+	}
+	for _, o := range q.orderBy {
+		add(o.col)
+	}
+	var walk func(e expr)
+	walk = func(e expr) {
+		switch e := e.(type) {
+		case andExpr:
+			walk(e.left)
+			walk(e.right)
+		case orExpr:
+			walk(e.left)
+			walk(e.right)
+		case notExpr:
+			walk(e.e)
+		case comparison:
+			for _, o := range []operand{e.left, e.right} {
+				if o.col != nil {
+					add(*o.col)
+				}
+			}
+		}
+	}
+	if q.where != nil {
+		walk(q.where)
+	}
+	return qs
+}
+
+// Select returns the names of the stations of r that match the WHERE clause
+// in the query's order, by ascending station name after the ORDER BY
+// columns, at most Limit of them.
+func (q *Query) Select(r onebrc.Result) []string {
+	names := make([]string, 0, len(r))
+	for name, s := range r {
+		if q.where == nil || q.where.eval(name, s, q.Collation) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		for _, o := range q.orderBy {
+			c := compare(o.col.value(a, r[a]), o.col.value(b, r[b]), q.Collation)
+			if c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return q.Collation.Compare(a, b) < 0
+	})
+	if q.Limit >= 0 && len(names) > q.Limit {
+		names = names[:q.Limit]
+	}
+	return names
+}
+
+func (q *Query) Format(w io.Writer, r onebrc.Result) error {
+	bw := bufio.NewWriter(w)
+	for i, name := range q.Header() {
+		if i > 0 {
+			bw.WriteByte('\t')
+		}
+		bw.WriteString(name)
+	}
+	bw.WriteByte('\n')
+
+	for _, name := range q.Select(r) {
+		for i, c := range q.columns {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			bw.WriteString(c.format(name, r[name]))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
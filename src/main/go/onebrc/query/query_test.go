@@ -0,0 +1,162 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"onebrc"
+	"onebrc/tdigest"
+)
+
+func testResult() onebrc.Result {
+	return onebrc.Result{
+		"Abha":     {Min: -50, Max: 400, Sum: 360, Count: 2},  // mean 18.0, range 45.0
+		"Bosaso":   {Min: 100, Max: 390, Sum: 1170, Count: 4}, // mean 29.25, range 29.0
+		"Cabo":     {Min: 180, Max: 180, Sum: 180, Count: 1},  // mean 18.0, range 0.0
+		"Dhaka":    {Min: -10, Max: 410, Sum: 600, Count: 3},  // mean 20.0, range 42.0
+		"Enschede": {Min: -200, Max: 250, Sum: 200, Count: 4}, // mean 5.0, range 45.0
+	}
+}
+
+func TestSelect(t *testing.T) {
+	r := testResult()
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT *", "[Abha Bosaso Cabo Dhaka Enschede]"},
+		{"select station where count > 2", "[Bosaso Dhaka Enschede]"},
+		{"SELECT station WHERE count >= 3 AND max > 40", "[Dhaka]"},
+		{"SELECT station WHERE mean = 18 OR min < -10", "[Abha Cabo Enschede]"},
+		{"SELECT station WHERE NOT (mean = 18 OR min < -10)", "[Bosaso Dhaka]"},
+		{"SELECT station WHERE count > 1 AND (range = 45 OR mean > 29)", "[Abha Bosaso Enschede]"},
+		{"SELECT station WHERE mean = 29.3", "[Bosaso]"}, // compared as written
+		{"SELECT station WHERE station <> 'Cabo' AND 'C' < station", "[Dhaka Enschede]"},
+		{"SELECT station WHERE name = 'Abha'", "[Abha]"},
+		{"SELECT station ORDER BY mean DESC", "[Bosaso Dhaka Abha Cabo Enschede]"},
+		{"SELECT station ORDER BY mean", "[Enschede Abha Cabo Dhaka Bosaso]"}, // tie broken by name
+		{"SELECT station ORDER BY range DESC, count ASC", "[Abha Enschede Dhaka Bosaso Cabo]"},
+		{"SELECT station ORDER BY station DESC LIMIT 2", "[Enschede Dhaka]"},
+		{"SELECT station ORDER BY sum DESC LIMIT 0", "[]"},
+		{"SELECT station WHERE sum < 0", "[]"},
+	} {
+		q, err := Parse(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if actual := fmt.Sprint(q.Select(r)); actual != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.query, tc.expected, actual)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	q, err := Parse("SELECT station, min, mean, max, sum, count, range WHERE count > 2 ORDER BY mean DESC LIMIT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := q.Format(&b, testResult()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "station\tmin\tmean\tmax\tsum\tcount\trange\n" +
+		"Bosaso\t10.0\t29.3\t39.0\t117.0\t4\t29.0\n" +
+		"Dhaka\t-1.0\t20.0\t41.0\t60.0\t3\t42.0\n"
+	if actual := b.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestQuantiles(t *testing.T) {
+	q, err := Parse("SELECT station, p50, P99.9 WHERE p95 > 9 OR p50 < 0 ORDER BY p50 DESC, p99.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := fmt.Sprint(q.Quantiles()); actual != "[0.5 0.999 0.95]" {
+		t.Errorf("expected quantiles [0.5 0.999 0.95], got %s", actual)
+	}
+
+	r := make(onebrc.Result)
+	for i, name := range []string{"Cold", "Warm"} {
+		s := &onebrc.Stats{Digest: tdigest.New(tdigest.DefaultCompression)}
+		for x := int64(1); x <= 100; x++ {
+			s.Add(x + int64(i)*100)
+		}
+		r[name] = s
+	}
+	r["Nodigest"] = &onebrc.Stats{Min: 1000, Max: 1000, Sum: 1000, Count: 1}
+
+	var b strings.Builder
+	if err := q.Format(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	// Stations without digests never match a quantile condition.
+	expected := "station\tp50\tp99.9\nWarm\t15.1\t20.0\nCold\t5.1\t10.0\n"
+	if actual := b.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"station, mean", 0, "expected SELECT, found \"station\""},
+		{"SELECT station, meen", 16, "unknown column \"meen\", available: station, min, max, mean, sum, count, range, pNN (did you mean \"mean\"?)"},
+		{"SELECT foo", 7, "unknown column \"foo\""},
+		{"SELECT station WHERE maen > 1", 21, "did you mean \"mean\"?"},
+		{"SELECT p101", 7, "invalid quantile column \"p101\""},
+		{"SELECT station WHERE", 20, "expected column, found end of query"},
+		{"SELECT station WHERE mean > 'x'", 21, "cannot compare a string with a number"},
+		{"SELECT station WHERE mean 5", 26, "expected comparison operator"},
+		{"SELECT station WHERE (mean > 5", 30, "expected ')'"},
+		{"SELECT station WHERE mean ! 5", 26, "did you mean '!='?"},
+		{"SELECT station WHERE station = 'x", 31, "unterminated string"},
+		{"SELECT station ORDER mean", 21, "expected BY, found \"mean\""},
+		{"SELECT station LIMIT -1", 21, "LIMIT wants a non-negative integer"},
+		{"SELECT station LIMIT 10 10", 24, "unexpected \"10\""},
+		{"SELECT station; DROP", 14, "unexpected ';'"},
+		{"SELECT WHERE", 7, "expected column, found \"WHERE\""},
+	} {
+		_, err := Parse(tc.query)
+		var qerr *Error
+		if !errors.As(err, &qerr) {
+			t.Errorf("%s: expected *Error, got %v", tc.query, err)
+			continue
+		}
+		if qerr.Pos != tc.pos || !strings.Contains(qerr.Msg, tc.msg) {
+			t.Errorf("%s: expected %q at %d, got %q at %d", tc.query, tc.msg, tc.pos, qerr.Msg, qerr.Pos)
+		}
+	}
+}
+
+func TestQuotedStrings(t *testing.T) {
+	q, err := Parse("SELECT station WHERE station = 'Cote d''Ivoire'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := onebrc.Result{"Cote d'Ivoire": {Count: 1}, "Cote": {Count: 1}}
+	if actual := fmt.Sprint(q.Select(r)); actual != "[Cote d'Ivoire]" {
+		t.Errorf("expected [Cote d'Ivoire], got %s", actual)
+	}
+}
+
+func TestCollation(t *testing.T) {
+	r := onebrc.Result{"Tokyo ｱ": {Count: 1}, "Tokyo 🙂": {Count: 1}}
+	q, err := Parse("SELECT station WHERE station < 'Tokyo ｱ' ORDER BY count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := fmt.Sprint(q.Select(r)); actual != "[Tokyo 🙂]" {
+		t.Errorf("UTF16: expected [Tokyo 🙂], got %s", actual)
+	}
+	q.Collation = onebrc.Bytes
+	if actual := fmt.Sprint(q.Select(r)); actual != "[]" {
+		t.Errorf("Bytes: expected [], got %s", actual)
+	}
+}
@@ -0,0 +1,137 @@
+// Package rank orders stations by one of their statistics, e.g. to list the
+// 20 hottest stations by mean. The ranking runs on the fully merged result,
+// when the formatter lists it, since a station's statistics are only final
+// after the last partial result was merged. Selecting the first N of many
+// stations uses a heap bounded to N entries instead of sorting all of them.
+package rank
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"onebrc"
+)
+
+// Key is the statistic stations are ordered by.
+type Key int
+
+const (
+	Name Key = iota
+	Mean
+	Min
+	Max
+	Range // Max - Min
+	Count
+)
+
+// Keys are the names accepted by ParseKey.
+var Keys = []string{"name", "mean", "min", "max", "range", "count"}
+
+// ParseKey parses one of Keys.
+func ParseKey(s string) (Key, error) {
+	for i, name := range Keys {
+		if s == name {
+			return Key(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sort key %q, available: %v", s, Keys)
+}
+
+func (k Key) String() string {
+	return Keys[k]
+}
+
+// value returns the statistic k of s, scaled so that values compare right.
+func (k Key) value(s *onebrc.Stats) float64 {
+	switch k {
+	case Mean:
+		return s.Mean()
+	case Min:
+		return float64(s.Min)
+	case Max:
+		return float64(s.Max)
+	case Range:
+		return float64(s.Max - s.Min)
+	case Count:
+		return float64(s.Count)
+	}
+	return 0
+}
+
+// Order sorts stations by Key, descending if Desc. Names, and so ties, are
+// compared by Collation.
+type Order struct {
+	Key       Key
+	Desc      bool
+	Collation onebrc.Collation
+}
+
+type entry struct {
+	name  string
+	value float64
+}
+
+// before reports whether a is listed before b.
+func (o Order) before(a, b entry) bool {
+	if o.Key == Name {
+		return (o.Collation.Compare(a.name, b.name) < 0) != o.Desc
+	}
+	if a.value != b.value {
+		return (a.value < b.value) != o.Desc
+	}
+	return o.Collation.Compare(a.name, b.name) < 0
+}
+
+// Top returns the names of the first n stations of the merged result r, all
+// if n <= 0.
+func (o Order) Top(r onebrc.Result, n int) []string {
+	if n <= 0 || n > len(r) {
+		n = len(r)
+	}
+
+	// keep the n first stations seen so far in a heap with the last of
+	// them at the root
+	h := &entryHeap{order: o, entries: make([]entry, 0, n)}
+	for name, s := range r {
+		e := entry{name, o.Key.value(s)}
+		if len(h.entries) < n {
+			heap.Push(h, e)
+		} else if n > 0 && o.before(e, h.entries[0]) {
+			h.entries[0] = e
+			heap.Fix(h, 0)
+		}
+	}
+
+	entries := h.entries
+	sort.Slice(entries, func(i, j int) bool { return o.before(entries[i], entries[j]) })
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.name
+	}
+	return names
+}
+
+// Names returns an ordering function for the Order field of the formatters
+// listing the first n stations, all if n <= 0.
+func (o Order) Names(n int) func(onebrc.Result) []string {
+	return func(r onebrc.Result) []string {
+		return o.Top(r, n)
+	}
+}
+
+// entryHeap is a heap with the entry listed last at the root.
+type entryHeap struct {
+	order   Order
+	entries []entry
+}
+
+func (h *entryHeap) Len() int           { return len(h.entries) }
+func (h *entryHeap) Less(i, j int) bool { return h.order.before(h.entries[j], h.entries[i]) }
+func (h *entryHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *entryHeap) Push(x any)         { h.entries = append(h.entries, x.(entry)) }
+func (h *entryHeap) Pop() any {
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return e
+}
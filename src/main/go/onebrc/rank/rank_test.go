@@ -0,0 +1,124 @@
+package rank
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"testing"
+
+	"onebrc"
+)
+
+func TestTop(t *testing.T) {
+	r := onebrc.Result{
+		"Abha":     {Min: -50, Max: 400, Sum: 360, Count: 2},  // mean 18.0, range 45.0
+		"Bosaso":   {Min: 100, Max: 390, Sum: 1170, Count: 4}, // mean 29.25, range 29.0
+		"Cabo":     {Min: 180, Max: 180, Sum: 180, Count: 1},  // mean 18.0, range 0.0
+		"Dhaka":    {Min: -10, Max: 410, Sum: 600, Count: 3},  // mean 20.0, range 42.0
+		"Enschede": {Min: -200, Max: 250, Sum: 200, Count: 4}, // mean 5.0, range 45.0
+	}
+	for _, tc := range []struct {
+		order    Order
+		n        int
+		expected string
+	}{
+		{Order{Key: Mean, Desc: true}, 2, "[Bosaso Dhaka]"},
+		{Order{Key: Mean, Desc: false}, 3, "[Enschede Abha Cabo]"}, // tie broken by name
+		{Order{Key: Min, Desc: false}, 1, "[Enschede]"},
+		{Order{Key: Max, Desc: true}, 0, "[Dhaka Abha Bosaso Enschede Cabo]"},
+		{Order{Key: Range, Desc: true}, 3, "[Abha Enschede Dhaka]"},
+		{Order{Key: Count, Desc: true}, 2, "[Bosaso Enschede]"},
+		{Order{Key: Name, Desc: false}, 10, "[Abha Bosaso Cabo Dhaka Enschede]"},
+		{Order{Key: Name, Desc: true}, 2, "[Enschede Dhaka]"},
+	} {
+		if actual := fmt.Sprint(tc.order.Top(r, tc.n)); actual != tc.expected {
+			t.Errorf("%v top %d: expected %s, got %s", tc.order, tc.n, tc.expected, actual)
+		}
+	}
+}
+
+// TestTopMatchesSort compares the bounded heap with a full sort.
+func TestTopMatchesSort(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	r := make(onebrc.Result)
+	for i := 0; i < 2000; i++ {
+		s := &onebrc.Stats{}
+		for j := rnd.IntN(5); j >= 0; j-- {
+			s.Add(rnd.Int64N(41) - 20) // plenty of ties
+		}
+		r[fmt.Sprintf("station-%d", i)] = s
+	}
+
+	for k := range Keys {
+		for _, desc := range []bool{false, true} {
+			o := Order{Key: Key(k), Desc: desc}
+			all := r.Names()
+			sort.SliceStable(all, func(i, j int) bool {
+				if o.Key == Name {
+					return (all[i] < all[j]) != desc
+				}
+				a, b := o.Key.value(r[all[i]]), o.Key.value(r[all[j]])
+				if a != b {
+					return (a < b) != desc
+				}
+				return false
+			})
+			for _, n := range []int{1, 7, 100, 2000} {
+				expected := strings.Join(all[:n], " ")
+				if actual := strings.Join(o.Top(r, n), " "); actual != expected {
+					t.Fatalf("%v top %d differs from a full sort", o, n)
+				}
+			}
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for i, name := range Keys {
+		k, err := ParseKey(name)
+		if err != nil || k != Key(i) || k.String() != name {
+			t.Errorf("%s: got %v %v", name, k, err)
+		}
+	}
+	if _, err := ParseKey("median"); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+}
+
+func TestFormatterOrder(t *testing.T) {
+	r := onebrc.Result{
+		"a": {Min: 10, Max: 10, Sum: 10, Count: 1},
+		"b": {Min: 30, Max: 30, Sum: 30, Count: 1},
+		"c": {Min: 20, Max: 20, Sum: 20, Count: 1},
+	}
+	var b strings.Builder
+	f := onebrc.BraceFormatter{Order: Order{Key: Mean, Desc: true}.Names(2)}
+	if err := f.Format(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{b=3.0/3.0/3.0, c=2.0/2.0/2.0}\n"; b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestCollation(t *testing.T) {
+	r := onebrc.Result{
+		"Tokyo ｱ": {Min: 10, Max: 10, Sum: 10, Count: 1},
+		"Tokyo 🙂": {Min: 10, Max: 10, Sum: 10, Count: 1},
+		"Abha":    {Min: 10, Max: 10, Sum: 10, Count: 1},
+	}
+	for _, tc := range []struct {
+		order    Order
+		expected string
+	}{
+		{Order{Key: Mean}, "[Abha Tokyo 🙂 Tokyo ｱ]"},
+		{Order{Key: Mean, Collation: onebrc.Bytes}, "[Abha Tokyo ｱ Tokyo 🙂]"},
+		{Order{Key: Name, Desc: true}, "[Tokyo ｱ Tokyo 🙂 Abha]"},
+		{Order{Key: Name, Desc: true, Collation: onebrc.Bytes}, "[Tokyo 🙂 Tokyo ｱ Abha]"},
+	} {
+		if actual := fmt.Sprint(tc.order.Top(r, 0)); actual != tc.expected {
+			t.Errorf("%v: expected %s, got %s", tc.order, tc.expected, actual)
+		}
+	}
+}
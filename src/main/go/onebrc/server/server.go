@@ -0,0 +1,247 @@
+// Package server exposes an aggregator over HTTP. Clients upload
+// measurements, which are merged into one concurrency safe store, and query
+// the results as JSON:
+//
+//	POST   /measurements     newline delimited station;temp lines
+//	GET    /stations         all stations sorted by name
+//	GET    /stations/{name}  one station
+//	DELETE /stations         drop everything ingested so far
+//	GET    /metrics          all stations and processing metrics for Prometheus
+//
+// Uploads are checked and then parsed with the split engine's block parser
+// outside of any lock, and only merged into the store once the whole body
+// turned out valid, so a rejected upload leaves no trace.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"onebrc"
+	"onebrc/engine/mmap"
+	"onebrc/engine/split"
+	"onebrc/metrics"
+)
+
+const (
+	// readBlockSize is the number of body bytes read at a time, and the
+	// longest line accepted.
+	readBlockSize = 1024 * 1024
+)
+
+// Store holds the aggregates of every accepted upload.
+type Store struct {
+	mu     sync.RWMutex
+	result onebrc.Result
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{result: make(onebrc.Result)}
+}
+
+// Add merges r into the store, taking ownership of r.
+func (s *Store) Add(r onebrc.Result) {
+	s.mu.Lock()
+	s.result.Merge(r)
+	s.mu.Unlock()
+}
+
+// Result returns a copy of the aggregates.
+func (s *Store) Result() onebrc.Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.result.Clone()
+}
+
+// Station returns the aggregates of one station.
+func (s *Store) Station(name string) (onebrc.Stats, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, ok := s.result[name]
+	if !ok {
+		return onebrc.Stats{}, false
+	}
+	return *stats, true
+}
+
+// Reset drops all aggregates.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	s.result = make(onebrc.Result)
+	s.mu.Unlock()
+}
+
+// Server is the HTTP handler for a Store.
+type Server struct {
+	Store   *Store
+	Metrics *metrics.Processing
+	mux     *http.ServeMux
+}
+
+// New returns a server with an empty store.
+func New() *Server {
+	s := &Server{Store: NewStore(), Metrics: metrics.NewProcessing(), mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /measurements", s.ingest)
+	s.mux.HandleFunc("GET /stations", s.stations)
+	s.mux.HandleFunc("GET /stations/{name}", s.station)
+	s.mux.HandleFunc("DELETE /stations", s.reset)
+	s.mux.Handle("GET /metrics", metrics.Handler(s.Store.Result, s.Metrics))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Station is the JSON representation of one station's aggregates, in degrees
+// rounded like the reference output.
+type Station struct {
+	Name  string  `json:"name"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int64   `json:"count"`
+}
+
+// NewStation converts stats to their JSON representation.
+func NewStation(name string, stats onebrc.Stats) Station {
+	return Station{
+		Name:  name,
+		Min:   float64(stats.Min) / 10,
+		Mean:  onebrc.Round(stats.Mean()),
+		Max:   float64(stats.Max) / 10,
+		Sum:   float64(stats.Sum) / 10,
+		Count: stats.Count,
+	}
+}
+
+// IngestResponse is the reply to an accepted upload.
+type IngestResponse struct {
+	Lines    int64 `json:"lines"`
+	Stations int   `json:"stations"`
+}
+
+// ErrorResponse is the reply to a failed request.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) ingest(w http.ResponseWriter, r *http.Request) {
+	result, lines, err := Parse(r.Body, s.Metrics)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	stations := len(result)
+	s.Store.Add(result)
+	writeJSON(w, http.StatusOK, IngestResponse{Lines: lines, Stations: stations})
+}
+
+func (s *Server) stations(w http.ResponseWriter, r *http.Request) {
+	result := s.Store.Result()
+	stations := make([]Station, 0, len(result))
+	for _, name := range result.Names() {
+		stations = append(stations, NewStation(name, *result[name]))
+	}
+	writeJSON(w, http.StatusOK, stations)
+}
+
+func (s *Server) station(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	stats, ok := s.Store.Station(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{fmt.Sprintf("unknown station %q", name)})
+		return
+	}
+	writeJSON(w, http.StatusOK, NewStation(name, stats))
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	s.Store.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Parse aggregates the measurements read from r and returns them with the
+// number of lines. The last line may omit the trailing newline. Unlike the
+// engines, which trust their input, it rejects malformed lines: the whole
+// body is checked line by line as it is read and only then aggregated in one
+// pass, so a rejected upload is never parsed. Bytes, lines and the parse
+// latency are recorded in m, which may be nil.
+func Parse(r io.Reader, m *metrics.Processing) (onebrc.Result, int64, error) {
+	var body []byte
+	var lines int64
+
+	buf := make([]byte, readBlockSize)
+	checked := 0 // the bytes of body made of checked lines
+	for {
+		n, err := io.ReadFull(r, buf)
+		m.Read(n)
+		body = append(body, buf[:n]...)
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return nil, 0, err
+		}
+
+		end := len(body)
+		if !eof {
+			end = checked + bytes.LastIndexByte(body[checked:], '\n') + 1
+			if end-checked == 0 && len(body)-checked >= readBlockSize {
+				return nil, 0, fmt.Errorf("line %d: too long", lines+1)
+			}
+		}
+		valid, err := validate(body[checked:end])
+		lines += valid
+		if err != nil {
+			m.Reject(1)
+			return nil, 0, fmt.Errorf("line %d: %w", lines+1, err)
+		}
+		checked = end
+
+		if eof {
+			break
+		}
+	}
+
+	start := time.Now()
+	result := make(onebrc.Result)
+	if err := split.ParseBlock(body, result); err != nil {
+		return nil, 0, err
+	}
+	m.Chunk(lines, time.Since(start))
+	return result, lines, nil
+}
+
+// validate checks the lines of data and returns their count. On error the
+// count is that of the valid lines before the bad one.
+func validate(data []byte) (int64, error) {
+	var n int64
+	for len(data) > 0 {
+		line := data
+		if newline := bytes.IndexByte(data, '\n'); newline >= 0 {
+			line, data = data[:newline], data[newline+1:]
+		} else {
+			data = nil
+		}
+
+		if err := mmap.CheckLine(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"onebrc"
+	"onebrc/engine/split"
+	"onebrc/metrics"
+)
+
+func do(t *testing.T, method, url, body string, v any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	var ingested IngestResponse
+	if code := do(t, "POST", ts.URL+"/measurements", "a;1.0\nb;-2.5\na;2.0", &ingested); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if ingested != (IngestResponse{Lines: 3, Stations: 2}) {
+		t.Fatalf("Unexpected response %+v", ingested)
+	}
+
+	var stations []Station
+	do(t, "GET", ts.URL+"/stations", "", &stations)
+	expected := []Station{
+		{Name: "a", Min: 1, Mean: 1.5, Max: 2, Sum: 3, Count: 2},
+		{Name: "b", Min: -2.5, Mean: -2.5, Max: -2.5, Sum: -2.5, Count: 1},
+	}
+	if fmt.Sprint(stations) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, stations)
+	}
+
+	var station Station
+	if code := do(t, "GET", ts.URL+"/stations/b", "", &station); code != http.StatusOK || station != expected[1] {
+		t.Fatalf("Expected %+v, got %d %+v", expected[1], code, station)
+	}
+
+	var e ErrorResponse
+	if code := do(t, "GET", ts.URL+"/stations/c", "", &e); code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d", code)
+	}
+
+	// a bad line rejects the whole upload
+	if code := do(t, "POST", ts.URL+"/measurements", "c;1.0\nc;x\n", &e); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", code)
+	}
+	if e.Error != `line 2: invalid temperature "x"` {
+		t.Fatalf("Unexpected error %q", e.Error)
+	}
+	if code := do(t, "GET", ts.URL+"/stations/c", "", nil); code != http.StatusNotFound {
+		t.Fatalf("Expected rejected upload to be dropped, got %d", code)
+	}
+
+	if code := do(t, "DELETE", ts.URL+"/stations", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	do(t, "GET", ts.URL+"/stations", "", &stations)
+	if len(stations) != 0 {
+		t.Fatalf("Expected no stations after reset, got %+v", stations)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	do(t, "POST", ts.URL+"/measurements", "a;1.0\nb;-2.5\na;2.0\n", nil)
+	do(t, "POST", ts.URL+"/measurements", "a;1.0\na;\n", nil)
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`station_temperature_mean{station="a"} 1.5`,
+		`station_temperature_count{station="b"} 1`,
+		"onebrc_bytes_read_total 28",
+		"onebrc_lines_parsed_total 3",
+		"onebrc_lines_rejected_total 1",
+		"onebrc_chunk_duration_seconds_count 1",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Missing %q in\n%s", line, body)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, tc := range []struct{ input, err string }{
+		{"a;1.0\n\n", "line 2: missing ';'"},
+		{";1.0\n", "line 1: empty station name"},
+		{strings.Repeat("x", 101) + ";1.0\n", "line 1: station name longer than 100 bytes"},
+		{"a;100.0\n", `line 1: invalid temperature "100.0"`},
+		{"a;1\n", `line 1: invalid temperature "1"`},
+		{"a;00012.3\nb;1.0\n", `line 1: invalid temperature "00012.3"`},
+		{"a;012.3\n", `line 1: invalid temperature "012.3"`},
+		{"a;-.3\n", `line 1: invalid temperature "-.3"`},
+	} {
+		if _, _, err := Parse(strings.NewReader(tc.input), nil); err == nil || err.Error() != tc.err {
+			t.Errorf("%q: expected error %q, got %v", tc.input, tc.err, err)
+		}
+	}
+}
+
+// TestParseSplitsChunks crosses read block limits with more distinct names
+// than the mmap engine's table could hold at once.
+func TestParseSplitsChunks(t *testing.T) {
+	var b strings.Builder
+	for i := 0; b.Len() < 3*readBlockSize; i++ {
+		fmt.Fprintf(&b, "station-%d;%d.%d\n", i%40000, i%100, i%10)
+	}
+	input := b.String()
+
+	result, lines, err := Parse(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines != int64(strings.Count(input, "\n")) {
+		t.Fatalf("Expected %d lines, got %d", strings.Count(input, "\n"), lines)
+	}
+
+	expected := make(onebrc.Result)
+	if err := split.ParseBlock([]byte(input), expected); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d stations, got %d", len(expected), len(result))
+	}
+	for name, e := range expected {
+		if a := result[name]; a == nil || *a != *e {
+			t.Fatalf("%s: expected %+v, got %+v", name, *e, a)
+		}
+	}
+}
+
+// TestParseRejectsBeforeParsing puts a bad line after blocks of valid ones,
+// none of which may be counted as parsed.
+func TestParseRejectsBeforeParsing(t *testing.T) {
+	input := strings.Repeat("a;1.0\n", 2*readBlockSize/6) + "bad\n"
+	m := metrics.NewProcessing()
+	if _, _, err := Parse(strings.NewReader(input), m); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if parsed, rejected := m.LinesParsed.Value(), m.LinesRejected.Value(); parsed != 0 || rejected != 1 {
+		t.Errorf("Expected 0 lines parsed and 1 rejected, got %d and %d", parsed, rejected)
+	}
+}
+
+// TestConcurrentUploads posts from many clients at once and checks that no
+// measurement is lost.
+func TestConcurrentUploads(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	const clients, uploads = 16, 20
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for u := 0; u < uploads; u++ {
+				var b strings.Builder
+				for i := 0; i < 500; i++ {
+					fmt.Fprintf(&b, "s%d;%d.%d\n", i%50, c, u%10)
+				}
+				resp, err := http.Post(ts.URL+"/measurements", "text/plain", strings.NewReader(b.String()))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("Expected 200, got %d", resp.StatusCode)
+				}
+			}
+		}(c)
+	}
+	// queries run alongside the uploads
+	for i := 0; i < 20; i++ {
+		var stations []Station
+		do(t, "GET", ts.URL+"/stations", "", &stations)
+	}
+	wg.Wait()
+
+	result := s.Store.Result()
+	if rows := result.Rows(); rows != clients*uploads*500 {
+		t.Fatalf("Expected %d rows, got %d", clients*uploads*500, rows)
+	}
+	if len(result) != 50 {
+		t.Fatalf("Expected 50 stations, got %d", len(result))
+	}
+	if s0 := result["s0"]; s0.Min != 0 || s0.Max != 159 {
+		t.Fatalf("Unexpected s0 %+v", *s0)
+	}
+}
@@ -0,0 +1,244 @@
+// Package snapshot saves and restores partial aggregates so results of
+// different runs, days or machines can be merged later.
+//
+// A snapshot file is, with all integers as varints:
+//
+//	magic    "1BRCSNAP"
+//	version  uvarint, currently 2
+//	stations uvarint count, then per station sorted by name:
+//	         uvarint name length, name, uvarint count, varint sum, min, max,
+//	         uvarint sum of squares plus one, 0 if unknown
+//	blocks   extension blocks: uvarint tag, uvarint length, data,
+//	         terminated by tag 0
+//	crc      4 bytes big endian CRC-32 (IEEE) of everything before
+//
+// Temperatures are in tenths of a degree, so sums are exact. Readers skip
+// extension blocks they do not know. Version 1 snapshots lack the sums of
+// squares, their stations are read as unknown, onebrc.Stats.SumSquares -1.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"onebrc"
+)
+
+const (
+	magic   = "1BRCSNAP"
+	Version = 2
+
+	// maxNameLen bounds the name length accepted by Read. It is generous
+	// compared to the 100 bytes of the spec.
+	maxNameLen = 1 << 16
+)
+
+// Extension is an optional block of data attached to a snapshot.
+type Extension struct {
+	Tag  uint64
+	Data []byte
+}
+
+// Snapshot is a set of per-station aggregates and optional extensions.
+type Snapshot struct {
+	Result     onebrc.Result
+	Extensions []Extension
+}
+
+// Write encodes s to w.
+func Write(w io.Writer, s *Snapshot) error {
+	var buf []byte
+	buf = append(buf, magic...)
+	buf = binary.AppendUvarint(buf, Version)
+
+	names := make([]string, 0, len(s.Result))
+	for name, st := range s.Result {
+		if st.Count > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		st := s.Result[name]
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+		buf = binary.AppendUvarint(buf, uint64(st.Count))
+		buf = binary.AppendVarint(buf, st.Sum)
+		buf = binary.AppendVarint(buf, st.Min)
+		buf = binary.AppendVarint(buf, st.Max)
+		buf = binary.AppendUvarint(buf, uint64(st.SumSquares+1))
+	}
+
+	for _, ext := range s.Extensions {
+		if ext.Tag == 0 {
+			return errors.New("extension tag 0 is reserved")
+		}
+		buf = binary.AppendUvarint(buf, ext.Tag)
+		buf = binary.AppendUvarint(buf, uint64(len(ext.Data)))
+		buf = append(buf, ext.Data...)
+	}
+	buf = binary.AppendUvarint(buf, 0)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// Read decodes a snapshot from r.
+func Read(r io.Reader) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(magic)+4 || string(data[:len(magic)]) != magic {
+		return nil, errors.New("not a snapshot")
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	d := decoder{r: bytes.NewReader(body[len(magic):])}
+	version := d.uvarint()
+	if d.err == nil && version != 1 && version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	n := d.uvarint()
+	s := &Snapshot{Result: make(onebrc.Result, min(n, onebrc.MaxStations))}
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		name := string(d.bytes(maxNameLen))
+		st := &onebrc.Stats{Count: int64(d.uvarint()), Sum: d.varint(), Min: d.varint(), Max: d.varint(), SumSquares: -1}
+		if version > 1 {
+			st.SumSquares = int64(d.uvarint()) - 1
+		}
+		if d.err != nil {
+			break
+		}
+		if _, dup := s.Result[name]; dup || st.Count <= 0 || st.Min > st.Max || st.SumSquares < -1 {
+			return nil, fmt.Errorf("invalid station %q in snapshot", name)
+		}
+		s.Result[name] = st
+	}
+
+	for d.err == nil {
+		tag := d.uvarint()
+		if tag == 0 {
+			break
+		}
+		s.Extensions = append(s.Extensions, Extension{Tag: tag, Data: d.bytes(len(body))})
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: %w", d.err)
+	}
+	if d.r.Len() != 0 {
+		return nil, errors.New("corrupt snapshot: trailing data")
+	}
+	return s, nil
+}
+
+// Merge combines snapshots into a new one. Extensions are specific to the
+// run that produced them and are not carried over.
+func Merge(snapshots ...*Snapshot) *Snapshot {
+	merged := &Snapshot{Result: make(onebrc.Result)}
+	for _, s := range snapshots {
+		for name, st := range s.Result {
+			if m := merged.Result[name]; m == nil {
+				c := *st
+				merged.Result[name] = &c
+			} else {
+				m.Merge(st)
+			}
+		}
+	}
+	return merged
+}
+
+// WriteFile writes s to path.
+func WriteFile(path string, s *Snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := Write(w, s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile reads the snapshot at path.
+func ReadFile(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.setErr(err)
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	d.setErr(err)
+	return v
+}
+
+func (d *decoder) bytes(limit int) []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(limit) || n > uint64(d.r.Len()) {
+		d.err = fmt.Errorf("length %d out of range", n)
+		return nil
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	d.setErr(err)
+	return b
+}
+
+func (d *decoder) setErr(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if d.err == nil {
+		d.err = err
+	}
+}
@@ -0,0 +1,112 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"onebrc"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s := &Snapshot{
+		Result: onebrc.Result{
+			"Abha":        {Min: -230, Max: 592, Sum: 1_234_567_890_123, Count: 68_000_000, SumSquares: 98_765_432_101_234},
+			"İzmir":       {Min: -999, Max: 999, Sum: 0, Count: 2, SumSquares: 1_996_002},
+			"a;b\nc":      {Min: 5, Max: 5, Sum: 5, Count: 1, SumSquares: -1},
+			"never added": {},
+		},
+		Extensions: []Extension{{Tag: 7, Data: []byte("payload")}, {Tag: 1 << 40, Data: nil}},
+	}
+
+	path := filepath.Join(t.TempDir(), "a.snap")
+	if err := WriteFile(path, s); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read.Result) != 3 {
+		t.Errorf("Expected 3 stations, got %d", len(read.Result))
+	}
+	for name, st := range read.Result {
+		if *st != *s.Result[name] {
+			t.Errorf("%q: expected %+v, got %+v", name, *s.Result[name], *st)
+		}
+	}
+	if len(read.Extensions) != 2 || read.Extensions[0].Tag != 7 || string(read.Extensions[0].Data) != "payload" ||
+		read.Extensions[1].Tag != 1<<40 || len(read.Extensions[1].Data) != 0 {
+		t.Errorf("Wrong extensions: %+v", read.Extensions)
+	}
+}
+
+func TestReadRejectsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, &Snapshot{Result: onebrc.Result{"a": {Min: 1, Max: 2, Sum: 3, Count: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for name, corrupt := range map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("XBRCSNAP"), data[8:]...),
+		"flipped":   append(append([]byte(nil), data[:10]...), append([]byte{data[10] ^ 1}, data[11:]...)...),
+		"truncated": data[:len(data)-5],
+	} {
+		if _, err := Read(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReadVersion1(t *testing.T) {
+	data := []byte(magic)
+	data = binary.AppendUvarint(data, 1)
+	data = binary.AppendUvarint(data, 1)
+	data = binary.AppendUvarint(data, 1)
+	data = append(data, 'a')
+	data = binary.AppendUvarint(data, 2)
+	for _, v := range []int64{30, 10, 20} {
+		data = binary.AppendVarint(data, v)
+	}
+	data = binary.AppendUvarint(data, 0)
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+
+	s, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := onebrc.Stats{Min: 10, Max: 20, Sum: 30, Count: 2, SumSquares: -1}
+	if a := s.Result["a"]; a == nil || *a != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, a)
+	}
+
+	// extending the restored stats, like -state does, keeps them unknown
+	a := s.Result["a"]
+	a.Add(40)
+	if a.SumSquares != -1 || !math.IsNaN(a.StdDev()) {
+		t.Errorf("Expected unknown squares after Add, got %d and deviation %v", a.SumSquares, a.StdDev())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := &Snapshot{Result: onebrc.Result{"x": {Min: -10, Max: 10, Sum: 0, Count: 2}}}
+	b := &Snapshot{Result: onebrc.Result{"x": {Min: -20, Max: 5, Sum: -15, Count: 2}, "y": {Min: 1, Max: 1, Sum: 1, Count: 1}}}
+
+	merged := Merge(a, b, a)
+
+	if s := *merged.Result["x"]; s != (onebrc.Stats{Min: -20, Max: 10, Sum: -15, Count: 6}) {
+		t.Errorf("Wrong merge of x: %+v", s)
+	}
+	if s := *merged.Result["y"]; s != (onebrc.Stats{Min: 1, Max: 1, Sum: 1, Count: 1}) {
+		t.Errorf("Wrong merge of y: %+v", s)
+	}
+	if s := *a.Result["x"]; s != (onebrc.Stats{Min: -10, Max: 10, Sum: 0, Count: 2}) {
+		t.Errorf("Merge modified its input: %+v", s)
+	}
+}
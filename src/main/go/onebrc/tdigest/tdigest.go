@@ -0,0 +1,208 @@
+// Package tdigest is a merging t-digest (Dunning and Ertl, "Computing
+// extremely accurate quantiles using t-digests", 2019), a mergeable sketch of
+// a distribution of unbounded values that is most accurate in the tails.
+//
+// Values are buffered and merged into centroids whose size is bounded by the
+// k1 scale function k(q) = δ/2π·asin(2q-1), so a digest holds about δ
+// centroids whatever the number of values. Quantiles are interpolated between
+// centroid means. With δ = 100 the rank error stays below 1%, and far below
+// that near the tails, see the tests.
+package tdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression is a compression δ giving about 100 centroids.
+const DefaultCompression = 100
+
+// Centroid is the mean of Weight values.
+type Centroid struct {
+	Mean, Weight float64
+}
+
+// Digest summarizes a distribution. The zero value is not usable, see New.
+type Digest struct {
+	compression float64
+	centroids   []Centroid // merged, sorted by mean
+	buffer      []Centroid // not merged yet
+	count       float64
+	min, max    float64
+}
+
+// New returns an empty digest with the given compression δ. Larger values are
+// more accurate and use more memory.
+func New(compression float64) *Digest {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	return &Digest{
+		compression: compression,
+		buffer:      make([]Centroid, 0, bufferSize(compression)),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func bufferSize(compression float64) int {
+	return int(5*compression) + 10
+}
+
+// Compression returns δ.
+func (d *Digest) Compression() float64 {
+	return d.compression
+}
+
+// Add records the value x.
+func (d *Digest) Add(x float64) {
+	d.AddWeighted(x, 1)
+}
+
+// AddWeighted records the value x w times.
+func (d *Digest) AddWeighted(x, w float64) {
+	if w <= 0 || math.IsNaN(x) {
+		return
+	}
+	d.buffer = append(d.buffer, Centroid{x, w})
+	d.count += w
+	d.min = math.Min(d.min, x)
+	d.max = math.Max(d.max, x)
+	if len(d.buffer) == cap(d.buffer) {
+		d.compress()
+	}
+}
+
+// Merge adds every value of o to d. o is not modified.
+func (d *Digest) Merge(o *Digest) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	d.buffer = append(d.buffer, o.centroids...)
+	d.buffer = append(d.buffer, o.buffer...)
+	d.count += o.count
+	d.min = math.Min(d.min, o.min)
+	d.max = math.Max(d.max, o.max)
+	d.compress()
+}
+
+// Clone returns a copy of d.
+func (d *Digest) Clone() *Digest {
+	c := *d
+	c.centroids = append([]Centroid(nil), d.centroids...)
+	c.buffer = append(make([]Centroid, 0, cap(d.buffer)), d.buffer...)
+	return &c
+}
+
+// Count returns the total weight of all values.
+func (d *Digest) Count() float64 {
+	return d.count
+}
+
+// Min returns the smallest value, +Inf if d is empty.
+func (d *Digest) Min() float64 {
+	return d.min
+}
+
+// Max returns the largest value, -Inf if d is empty.
+func (d *Digest) Max() float64 {
+	return d.max
+}
+
+// Centroids returns the centroids of d sorted by mean. The slice must not be
+// modified.
+func (d *Digest) Centroids() []Centroid {
+	d.compress()
+	return d.centroids
+}
+
+// compress merges the buffer into the centroids.
+func (d *Digest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := append(d.buffer, d.centroids...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Mean < all[j].Mean })
+
+	merged := make([]Centroid, 0, int(2*d.compression)+1)
+	cur := all[0]
+	weightSoFar := 0.0
+	qLimit := d.qLimit(0)
+	for _, c := range all[1:] {
+		if (weightSoFar+cur.Weight+c.Weight)/d.count <= qLimit {
+			cur.Weight += c.Weight
+			cur.Mean += (c.Mean - cur.Mean) * c.Weight / cur.Weight
+			continue
+		}
+		merged = append(merged, cur)
+		weightSoFar += cur.Weight
+		qLimit = d.qLimit(weightSoFar / d.count)
+		cur = c
+	}
+	d.centroids = append(merged, cur)
+	d.buffer = make([]Centroid, 0, bufferSize(d.compression))
+}
+
+// qLimit returns the largest quantile a centroid starting at q may reach,
+// the one whose scale is one larger: k⁻¹(k(q)+1).
+func (d *Digest) qLimit(q float64) float64 {
+	k := d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+	k++
+	if k >= d.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/d.compression) + 1) / 2
+}
+
+// Quantile returns an estimate of the value at quantile q in [0, 1], NaN if
+// d is empty.
+func (d *Digest) Quantile(q float64) float64 {
+	d.compress()
+	if d.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	switch {
+	case q <= 0:
+		return d.min
+	case q >= 1:
+		return d.max
+	}
+
+	cs := d.centroids
+	target := q * d.count
+
+	// below the center of the first centroid interpolate from the minimum
+	if first := cs[0]; target < first.Weight/2 {
+		if first.Weight == 1 {
+			return d.min
+		}
+		return d.min + (first.Mean-d.min)*target/(first.Weight/2)
+	}
+
+	cumulative := 0.0 // weight before the current centroid
+	for i := 0; i < len(cs)-1; i++ {
+		left, right := cs[i], cs[i+1]
+		leftCenter := cumulative + left.Weight/2
+		rightCenter := cumulative + left.Weight + right.Weight/2
+		if target < rightCenter {
+			// singletons are exact values, do not interpolate into them
+			if left.Weight == 1 && target-cumulative < 1 {
+				return left.Mean
+			}
+			if right.Weight == 1 && target >= cumulative+left.Weight {
+				return right.Mean
+			}
+			t := (target - leftCenter) / (rightCenter - leftCenter)
+			return left.Mean + t*(right.Mean-left.Mean)
+		}
+		cumulative += left.Weight
+	}
+
+	// above the center of the last centroid interpolate to the maximum
+	last := cs[len(cs)-1]
+	if last.Weight == 1 {
+		return d.max
+	}
+	lastCenter := d.count - last.Weight/2
+	return last.Mean + (d.max-last.Mean)*(target-lastCenter)/(last.Weight/2)
+}
@@ -0,0 +1,147 @@
+package tdigest
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+// rankError returns how far the rank of estimate in the sorted values is from
+// q. Values equal to estimate count as either side, so ties are not errors.
+func rankError(sorted []float64, q, estimate float64) float64 {
+	n := float64(len(sorted))
+	below := float64(sort.SearchFloat64s(sorted, estimate)) / n
+	atOrBelow := float64(sort.Search(len(sorted), func(i int) bool { return sorted[i] > estimate })) / n
+	switch {
+	case q < below:
+		return below - q
+	case q > atOrBelow:
+		return q - atOrBelow
+	}
+	return 0
+}
+
+func checkAccuracy(t *testing.T, d *Digest, values []float64) {
+	t.Helper()
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	for _, q := range []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999} {
+		// relatively tighter in the tails
+		bound := 0.01 * math.Max(4*q*(1-q), 0.05)
+		if e := rankError(sorted, q, d.Quantile(q)); e > bound {
+			t.Errorf("q=%v: rank error %.5f exceeds %.5f (estimate %v)", q, e, bound, d.Quantile(q))
+		}
+	}
+	if d.Quantile(0) != sorted[0] || d.Quantile(1) != sorted[len(sorted)-1] {
+		t.Errorf("Expected min %v and max %v, got %v and %v", sorted[0], sorted[len(sorted)-1], d.Quantile(0), d.Quantile(1))
+	}
+	if d.Count() != float64(len(values)) {
+		t.Errorf("Expected count %d, got %v", len(values), d.Count())
+	}
+}
+
+func generate(name string, n int) []float64 {
+	r := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, n)
+	for i := range values {
+		switch name {
+		case "normal":
+			values[i] = r.NormFloat64()*10 + 15
+		case "uniform":
+			values[i] = r.Float64() * 1000
+		case "exponential":
+			values[i] = r.ExpFloat64() * 1e6
+		case "tenths":
+			// rounded temperatures in tenths, lots of ties
+			values[i] = math.Round(r.NormFloat64()*100 + 150)
+		}
+	}
+	return values
+}
+
+func TestAccuracy(t *testing.T) {
+	for _, name := range []string{"normal", "uniform", "exponential", "tenths"} {
+		t.Run(name, func(t *testing.T) {
+			values := generate(name, 200_000)
+			d := New(100)
+			for _, v := range values {
+				d.Add(v)
+			}
+			checkAccuracy(t, d, values)
+			if n := len(d.Centroids()); n > 200 {
+				t.Errorf("Expected at most 200 centroids, got %d", n)
+			}
+		})
+	}
+}
+
+// TestMergeAccuracy builds one digest per chunk like the engines do and
+// merges them.
+func TestMergeAccuracy(t *testing.T) {
+	values := generate("normal", 200_000)
+	merged := New(100)
+	for chunk := 0; chunk < len(values); chunk += 1000 {
+		d := New(100)
+		for _, v := range values[chunk : chunk+1000] {
+			d.Add(v)
+		}
+		merged.Merge(d)
+	}
+	checkAccuracy(t, merged, values)
+}
+
+func TestCompressionImprovesAccuracy(t *testing.T) {
+	values := generate("uniform", 100_000)
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	maxError := func(compression float64) float64 {
+		d := New(compression)
+		for _, v := range values {
+			d.Add(v)
+		}
+		worst := 0.0
+		for q := 0.01; q < 1; q += 0.01 {
+			worst = math.Max(worst, rankError(sorted, q, d.Quantile(q)))
+		}
+		return worst
+	}
+	coarse, fine := maxError(20), maxError(500)
+	if fine >= coarse {
+		t.Errorf("Expected δ=500 to be more accurate than δ=20, got %v and %v", fine, coarse)
+	}
+	if fine > 0.001 {
+		t.Errorf("Expected a rank error below 0.1%% for δ=500, got %v", fine)
+	}
+}
+
+func TestSmall(t *testing.T) {
+	d := New(100)
+	if !math.IsNaN(d.Quantile(0.5)) {
+		t.Errorf("Expected NaN for an empty digest, got %v", d.Quantile(0.5))
+	}
+
+	for _, v := range []float64{3, 1, 2} {
+		d.Add(v)
+	}
+	for q, expected := range map[float64]float64{0: 1, 0.2: 1, 0.5: 2, 0.9: 3, 1: 3} {
+		if actual := d.Quantile(q); actual != expected {
+			t.Errorf("q=%v: expected %v, got %v", q, expected, actual)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	d := New(100)
+	d.Add(1)
+	c := d.Clone()
+	c.Add(100)
+	if d.Count() != 1 || d.Max() != 1 {
+		t.Errorf("Clone shares state with the original: count %v, max %v", d.Count(), d.Max())
+	}
+	if c.Count() != 2 || c.Max() != 100 {
+		t.Errorf("Unexpected clone: count %v, max %v", c.Count(), c.Max())
+	}
+}
@@ -0,0 +1,304 @@
+// Package timeseries aggregates measurements with a timestamp column,
+//
+//	station;timestamp;temperature
+//
+// per station and time bucket. The timestamp is either RFC 3339, e.g.
+// 2024-01-31T23:59:59Z, or seconds since the Unix epoch. Buckets are hours,
+// days or months in UTC. Files without the timestamp column are left to the
+// engines, see HasTimestamps.
+package timeseries
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"onebrc"
+	"onebrc/engine/compressed"
+	"onebrc/engine/split"
+)
+
+// Granularity is the length of a time bucket.
+type Granularity int
+
+const (
+	Hour Granularity = iota
+	Day
+	Month
+)
+
+// Granularities are the names accepted by ParseGranularity.
+var Granularities = []string{"hour", "day", "month"}
+
+// ParseGranularity parses one of Granularities.
+func ParseGranularity(s string) (Granularity, error) {
+	for i, name := range Granularities {
+		if s == name {
+			return Granularity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown bucket %q, available: %v", s, Granularities)
+}
+
+func (g Granularity) String() string {
+	return Granularities[g]
+}
+
+// Start returns the start of the bucket holding the Unix time sec, in Unix
+// seconds.
+func (g Granularity) Start(sec int64) int64 {
+	switch g {
+	case Hour:
+		return floor(sec, 3600)
+	case Day:
+		return floor(sec, 24*3600)
+	}
+	t := time.Unix(sec, 0).UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+func floor(x, unit int64) int64 {
+	if x < 0 {
+		x -= unit - 1
+	}
+	return x / unit * unit
+}
+
+// Format returns the label of the bucket starting at the Unix time start.
+func (g Granularity) Format(start int64) string {
+	layout := [...]string{"2006-01-02T15", "2006-01-02", "2006-01"}[g]
+	return time.Unix(start, 0).UTC().Format(layout)
+}
+
+// Buckets maps bucket starts, in Unix seconds, to their aggregates.
+type Buckets map[int64]*onebrc.Stats
+
+// Result maps station names to their buckets.
+type Result map[string]Buckets
+
+// Merge folds every bucket of o into r, taking over new ones from o.
+func (r Result) Merge(o Result) {
+	for name, ob := range o {
+		b := r[name]
+		if b == nil {
+			r[name] = ob
+			continue
+		}
+		for start, os := range ob {
+			if s := b[start]; s == nil {
+				b[start] = os
+			} else {
+				s.Merge(os)
+			}
+		}
+	}
+}
+
+// Starts returns the bucket starts of b in ascending order.
+func (b Buckets) Starts() []int64 {
+	starts := make([]int64, 0, len(b))
+	for start := range b {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts
+}
+
+// Names returns the station names of r in output order, see onebrc.UTF16.
+func (r Result) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	onebrc.UTF16.Sort(names)
+	return names
+}
+
+// WriteTable writes r as a tab separated table with one row per station and
+// bucket, the temperatures rounded like the reference output.
+func WriteTable(w io.Writer, r Result, g Granularity) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "station\tbucket\tmin\tmean\tmax\tcount")
+	for _, name := range r.Names() {
+		b := r[name]
+		for _, start := range b.Starts() {
+			s := b[start]
+			fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%s\t%d\n", name, g.Format(start),
+				onebrc.FormatTenths(s.Min), onebrc.FormatTemp(s.Mean()), onebrc.FormatTenths(s.Max), s.Count)
+		}
+	}
+	return bw.Flush()
+}
+
+// HasTimestamps reports whether the first line of the file at path has a
+// timestamp column. Compressed files never have one.
+func HasTimestamps(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if format, err := compressed.Detect(f); err != nil || format != compressed.Plain {
+		return false, err
+	}
+
+	line, err := bufio.NewReader(f).ReadSlice('\n')
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return false, err
+	}
+	return bytes.Count(line, []byte{';'}) >= 2, nil
+}
+
+// Aggregate aggregates the file at path with opts.NumWorkers() workers, the
+// rows selected by opts.Filter and the station names folded by opts.Aliases.
+func Aggregate(path string, g Granularity, opts onebrc.Options) (Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	parts, err := split.SplitFile(f, st.Size(), opts.NumWorkers())
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(parts))
+	errs := make(chan error, len(parts))
+	for i, p := range parts {
+		results[i] = make(Result)
+		go func(p split.Part, r Result) {
+			errs <- split.ReadBlocks(io.NewSectionReader(f, p.Offset, p.Size), func(block []byte) error {
+				return ParseBlockWith(block, g, r, opts)
+			})
+		}(p, results[i])
+	}
+	for range parts {
+		if e := <-errs; e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(Result)
+	for _, r := range results {
+		result.Merge(r)
+	}
+	return result, nil
+}
+
+// ParseBlock accumulates the lines of block into r. Empty lines are skipped.
+func ParseBlock(block []byte, g Granularity, r Result) error {
+	return ParseBlockWith(block, g, r, onebrc.Options{})
+}
+
+// ParseBlockWith is ParseBlock with the rows selected by opts.Filter and the
+// station names folded by opts.Aliases.
+func ParseBlockWith(block []byte, g Granularity, r Result, opts onebrc.Options) error {
+	filter := opts.Filter
+	var (
+		accepted              map[string]bool // filter decision per station
+		stationRows, tempRows int64
+	)
+	if filter != nil {
+		accepted = make(map[string]bool)
+		defer func() { filter.Count(stationRows, tempRows) }()
+	}
+	aliases := opts.Aliases
+	var variants onebrc.Variants
+	if aliases != nil {
+		variants = make(onebrc.Variants)
+		defer aliases.Count(variants)
+	}
+
+	for len(block) > 0 {
+		var line []byte
+		if newline := bytes.IndexByte(block, '\n'); newline >= 0 {
+			line, block = block[:newline], block[newline+1:]
+		} else {
+			line, block = block, nil
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		semi := bytes.IndexByte(line, ';')
+		last := bytes.LastIndexByte(line, ';')
+		if semi < 0 || semi == last {
+			return fmt.Errorf("missing timestamp in %q", line)
+		}
+		sec, err := ParseTimestamp(line[semi+1 : last])
+		if err != nil {
+			return err
+		}
+		temp, ok := split.ParseTenths(line[last+1:])
+		if !ok {
+			return fmt.Errorf("error parsing temperature %q", line[last+1:])
+		}
+
+		station := line[:semi]
+		var v *onebrc.Variant
+		if aliases != nil {
+			v = variants.Resolve(aliases, station)
+			station = v.Bytes()
+		}
+		if filter != nil {
+			ok, seen := accepted[string(station)]
+			if !seen {
+				ok = filter.AcceptStation(station)
+				accepted[string(station)] = ok
+			}
+			if !ok {
+				stationRows++
+				continue
+			}
+			if !filter.AcceptTemp(temp) {
+				tempRows++
+				continue
+			}
+		}
+
+		// the string conversion in a map index expression does not allocate
+		b := r[string(station)]
+		if b == nil {
+			b = make(Buckets)
+			r[string(station)] = b
+		}
+		start := g.Start(sec)
+		s := b[start]
+		if s == nil {
+			s = &onebrc.Stats{}
+			b[start] = s
+		}
+		s.Add(temp)
+		if v != nil {
+			v.Rows++
+		}
+	}
+	return nil
+}
+
+// ParseTimestamp parses epoch seconds or an RFC 3339 timestamp and returns
+// Unix seconds.
+func ParseTimestamp(b []byte) (int64, error) {
+	if sec, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		return sec, nil
+	}
+	t, err := time.Parse(time.RFC3339, string(b))
+	if err != nil {
+		return 0, fmt.Errorf("error parsing timestamp %q, expected RFC 3339 or epoch seconds", b)
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,169 @@
+package timeseries
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"onebrc"
+)
+
+func TestGranularity(t *testing.T) {
+	for _, tc := range []struct {
+		g        Granularity
+		sec      int64
+		expected string
+	}{
+		{Hour, 1706745599, "2024-01-31T23"},
+		{Day, 1706745599, "2024-01-31"},
+		{Day, 1706745600, "2024-02-01"},
+		{Month, 1706745599, "2024-01"},
+		{Month, 1709251199, "2024-02"}, // 2024-02-29T23:59:59Z
+		{Hour, -1, "1969-12-31T23"},
+		{Day, -86400, "1969-12-31"},
+		{Day, -86401, "1969-12-30"},
+	} {
+		start := tc.g.Start(tc.sec)
+		if start > tc.sec {
+			t.Errorf("%v %d: bucket starts after the timestamp at %d", tc.g, tc.sec, start)
+		}
+		if label := tc.g.Format(start); label != tc.expected {
+			t.Errorf("%v %d: expected %s, got %s", tc.g, tc.sec, tc.expected, label)
+		}
+	}
+
+	if _, err := ParseGranularity("week"); err == nil {
+		t.Error("Expected an error for an unknown bucket")
+	}
+	if g, err := ParseGranularity("month"); err != nil || g != Month {
+		t.Errorf("Expected month, got %v %v", g, err)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for in, expected := range map[string]int64{
+		"1706745599":                1706745599,
+		"-5":                        -5,
+		"2024-01-31T23:59:59Z":      1706745599,
+		"2024-02-01T01:59:59+02:00": 1706745599,
+		"2024-01-31T23:59:59.5Z":    1706745599,
+	} {
+		sec, err := ParseTimestamp([]byte(in))
+		if err != nil || sec != expected {
+			t.Errorf("%s: expected %d, got %d %v", in, expected, sec, err)
+		}
+	}
+	for _, in := range []string{"", "yesterday", "2024-01-31", "1.5"} {
+		if _, err := ParseTimestamp([]byte(in)); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 100; i++ {
+		b.WriteString("Hamburg;2024-01-31T23:30:00Z;1.0\n")
+		b.WriteString("Hamburg;1706745600;3.0\n") // 2024-02-01T00:00:00Z
+		b.WriteString("Abha;2024-02-01T01:00:00+02:00;-2.5\n")
+	}
+	b.WriteString("Abha;2024-02-15T12:00:00Z;10.0")
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := HasTimestamps(path)
+	if err != nil || !ok {
+		t.Fatalf("Expected timestamps, got %v %v", ok, err)
+	}
+
+	for _, tc := range []struct {
+		g        Granularity
+		expected string
+	}{
+		{Day, `station	bucket	min	mean	max	count
+Abha	2024-01-31	-2.5	-2.5	-2.5	100
+Abha	2024-02-15	10.0	10.0	10.0	1
+Hamburg	2024-01-31	1.0	1.0	1.0	100
+Hamburg	2024-02-01	3.0	3.0	3.0	100
+`},
+		{Month, `station	bucket	min	mean	max	count
+Abha	2024-01	-2.5	-2.5	-2.5	100
+Abha	2024-02	10.0	10.0	10.0	1
+Hamburg	2024-01	1.0	1.0	1.0	100
+Hamburg	2024-02	3.0	3.0	3.0	100
+`},
+	} {
+		for _, workers := range []int{1, 4} {
+			result, err := Aggregate(path, tc.g, onebrc.Options{Workers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			if err := WriteTable(&out, result, tc.g); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("%v with %d workers: expected\n%s\ngot\n%s", tc.g, workers, tc.expected, out.String())
+			}
+		}
+	}
+}
+
+func TestHasTimestampsPlain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte("Hamburg;1.0\nAbha;2.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := HasTimestamps(path); err != nil || ok {
+		t.Fatalf("Expected no timestamps, got %v %v", ok, err)
+	}
+}
+
+func TestParseBlockFilter(t *testing.T) {
+	filter := onebrc.NewFilter()
+	filter.Exclude = regexp.MustCompile("^Abha$")
+	filter.Max = 20
+	r := make(Result)
+	block := "Hamburg;0;1.0\nAbha;0;1.0\nHamburg;0;3.0\nAbha;0;2.0\n"
+	if err := ParseBlockWith([]byte(block), Day, r, onebrc.Options{Filter: filter}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r["Hamburg"] == nil || *r["Hamburg"][0] != (onebrc.Stats{Min: 10, Max: 10, Sum: 10, Count: 1, SumSquares: 100}) {
+		t.Fatalf("Expected one Hamburg reading, got %v", r)
+	}
+	if stationRows, tempRows := filter.Rejected(); stationRows != 2 || tempRows != 1 {
+		t.Fatalf("Expected 2 station and 1 temperature rejections, got %d and %d", stationRows, tempRows)
+	}
+}
+
+func TestParseBlockAliases(t *testing.T) {
+	aliases := &onebrc.Aliases{TrimSpace: true}
+	if err := aliases.Add("HH", "Hamburg"); err != nil {
+		t.Fatal(err)
+	}
+	r := make(Result)
+	block := "Hamburg;0;1.0\n Hamburg ;0;2.0\nHH;0;3.0\n"
+	if err := ParseBlockWith([]byte(block), Day, r, onebrc.Options{Aliases: aliases}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r["Hamburg"] == nil || *r["Hamburg"][0] != (onebrc.Stats{Min: 10, Max: 30, Sum: 60, Count: 3, SumSquares: 1400}) {
+		t.Fatalf("Expected three Hamburg readings, got %v", r)
+	}
+	expected := map[string]map[string]int64{"Hamburg": {" Hamburg ": 1, "HH": 1}}
+	if folded := aliases.Folded(); !reflect.DeepEqual(folded, expected) {
+		t.Fatalf("Expected folded %v, got %v", expected, folded)
+	}
+}
+
+func TestParseBlockErrors(t *testing.T) {
+	for _, line := range []string{"Hamburg;1.0", "Hamburg;today;1.0", "Hamburg;0;1"} {
+		if err := ParseBlock([]byte(line), Day, make(Result)); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}